@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/rancher/example-kontainer-engine-driver/client"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// tokenEnvVar holds the token itself, it is not a flag so that it does not show up in ps
+const tokenEnvVar = "MYDRIVER_TOKEN"
+
+// loadToken returns the token RPCs have to present, from the environment or from tokenFile.
+// No token at all disables the check.
+func loadToken(tokenFile string) (string, error) {
+	token := os.Getenv(tokenEnvVar)
+	if tokenFile != "" {
+		if token != "" {
+			return "", fmt.Errorf("%s cannot be combined with --token-file", tokenEnvVar)
+		}
+		data, err := ioutil.ReadFile(tokenFile)
+		if err != nil {
+			return "", fmt.Errorf("error reading token file: %v", err)
+		}
+		if token = strings.TrimSpace(string(data)); token == "" {
+			return "", fmt.Errorf("token file %s is empty", tokenFile)
+		}
+	}
+	return token, nil
+}
+
+// tokenAuth rejects RPCs that do not carry token in their authorization metadata
+func tokenAuth(token string) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if err := checkToken(ctx, token); err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+// tokenAuthStream is tokenAuth for streaming RPCs, only the reflection service has those
+func tokenAuthStream(token string) grpc.StreamServerInterceptor {
+	return func(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if err := checkToken(stream.Context(), token); err != nil {
+			return err
+		}
+		return handler(srv, stream)
+	}
+}
+
+func checkToken(ctx context.Context, token string) error {
+	md, _ := metadata.FromIncomingContext(ctx)
+	values := md[client.AuthorizationKey]
+	if len(values) == 0 {
+		return status.Errorf(codes.Unauthenticated, "missing %s metadata", client.AuthorizationKey)
+	}
+	presented := strings.TrimPrefix(values[0], client.BearerPrefix)
+	if presented == values[0] || subtle.ConstantTimeCompare([]byte(presented), []byte(token)) != 1 {
+		return status.Errorf(codes.Unauthenticated, "invalid token")
+	}
+	return nil
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/rancher/example-kontainer-engine-driver/client"
+	"google.golang.org/grpc/codes"
+)
+
+func TestTokenAuth(t *testing.T) {
+	tests := []struct {
+		name        string
+		serverToken string
+		clientToken string
+		wantCode    codes.Code
+	}{
+		{name: "no token configured", clientToken: "anything", wantCode: codes.OK},
+		{name: "matching token", serverToken: "s3cr3t", clientToken: "s3cr3t", wantCode: codes.OK},
+		{name: "missing token", serverToken: "s3cr3t", wantCode: codes.Unauthenticated},
+		{name: "wrong token", serverToken: "s3cr3t", clientToken: "guess", wantCode: codes.Unauthenticated},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s := newRPCServer(newMyDriver(), rpcServerOptions{Token: test.serverToken})
+			defer s.Shutdown(0, 0)
+			driver, err := client.NewClient("mydriver", serveLocal(t, s), client.Options{Token: test.clientToken})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			_, err = driver.GetDriverCreateOptions(context.Background())
+			if code := errorCode(err); code != test.wantCode {
+				t.Errorf("the RPC got %v, want code %s", err, test.wantCode)
+			}
+		})
+	}
+}
+
+func TestLoadToken(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mydriver")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	tokenFile := filepath.Join(dir, "token")
+	if err := ioutil.WriteFile(tokenFile, []byte("from-file\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	emptyFile := filepath.Join(dir, "empty")
+	if err := ioutil.WriteFile(emptyFile, []byte("\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		env       string
+		tokenFile string
+		want      string
+		wantErr   string
+	}{
+		{name: "none"},
+		{name: "environment", env: "from-env", want: "from-env"},
+		{name: "file", tokenFile: tokenFile, want: "from-file"},
+		{name: "both", env: "from-env", tokenFile: tokenFile, wantErr: "MYDRIVER_TOKEN cannot be combined with --token-file"},
+		{name: "empty file", tokenFile: emptyFile, wantErr: "token file " + emptyFile + " is empty"},
+	}
+
+	defer os.Setenv(tokenEnvVar, os.Getenv(tokenEnvVar))
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			os.Setenv(tokenEnvVar, test.env)
+			got, err := loadToken(test.tokenFile)
+			if test.wantErr != "" {
+				if err == nil || err.Error() != test.wantErr {
+					t.Fatalf("error is %v, want %q", err, test.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != test.want {
+				t.Errorf("token is %q, want %q", got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+
+	"github.com/rancher/kontainer-engine/types"
+)
+
+// clusterBackend provisions and tears down the clusters MyDriver manages
+type clusterBackend interface {
+	// Name identifies the backend in the persisted cluster state
+	Name() string
+
+	// CreateSteps are the steps that provision the cluster described by state, in order. Every
+	// step records how to reach the cluster in the cluster info as it learns it
+	CreateSteps() []createStep
+
+	// Remove tears the cluster down, removing an unknown cluster is not an error
+	Remove(ctx context.Context, state *state) error
+}
+
+// createStep is a checkpointed unit of provisioning work. A step is only skipped once it
+// completed, so run has to cope with whatever an interrupted earlier attempt left behind.
+// undo reverts a completed step when a failed create is rolled back, it may be nil for steps
+// that leave nothing behind. done reports whether the work of a completed step is still in
+// place, a step whose work is gone runs again along with every later step. A nil done trusts
+// the checkpoint.
+type createStep struct {
+	name string
+	run  func(ctx context.Context, state *state, info *types.ClusterInfo) error
+	undo func(ctx context.Context, state *state, info *types.ClusterInfo) error
+	done func(state *state) bool
+}
+
+// versionReader is implemented by backends that can report the Kubernetes version of a cluster
+type versionReader interface {
+	GetVersion(ctx context.Context, state *state) (string, error)
+}
+
+// versionWriter is implemented by backends that can change the Kubernetes version of a cluster
+type versionWriter interface {
+	SetVersion(ctx context.Context, state *state, version string) error
+}
+
+// adminTokenRevoker is implemented by backends that hand out an admin token while the cluster
+// is created, PostCheck revokes it once the bootstrap service account has a token of its own
+type adminTokenRevoker interface {
+	RevokeAdminToken(ctx context.Context, state *state) error
+}
+
+// sizeReader is implemented by backends that can report the number of nodes in a cluster
+type sizeReader interface {
+	GetClusterSize(ctx context.Context, state *state) (int64, error)
+}
+
+// sizeWriter is implemented by backends that can resize the node pools of a cluster
+type sizeWriter interface {
+	// GetNodePoolSizes maps the name of every node pool of the cluster to its number of nodes
+	GetNodePoolSizes(ctx context.Context, state *state) (map[string]int64, error)
+	// SetNodePools makes the nodes of the cluster match state.NodePools
+	SetNodePools(ctx context.Context, state *state) error
+}
+
+// healthChecker is implemented by backends that can tell whether they are able to provision,
+// an error marks the backend unavailable in the gRPC health service
+type healthChecker interface {
+	CheckHealth(ctx context.Context) error
+}
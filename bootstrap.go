@@ -0,0 +1,307 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/rancher/kontainer-engine/types"
+	"k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	defaultBootstrapNamespace      = "default"
+	defaultBootstrapServiceAccount = "netes-default"
+	defaultBootstrapClusterRole    = "cluster-admin"
+
+	// managedRolePrefix names the ClusterRoles and ClusterRoleBindings MyDriver owns
+	managedRolePrefix = "mydriver:"
+)
+
+// bootstrapIdentity is the service account Rancher manages the cluster with and what it may do
+type bootstrapIdentity struct {
+	// The name of the service account
+	ServiceAccount string
+	// The namespace of the service account
+	Namespace string
+	// The existing ClusterRole bound to the service account, empty when Rules are given
+	ClusterRole string
+	// The rules of the ClusterRole MyDriver manages for the service account, in the
+	// cluster-role-rules option format
+	Rules []string
+	// The ClusterRoleBinding granting the role, set once the identity has been created
+	Binding string
+}
+
+// sameGrant reports whether two identities are the same service account with the same permissions
+func (b bootstrapIdentity) sameGrant(other bootstrapIdentity) bool {
+	return b.ServiceAccount == other.ServiceAccount &&
+		b.Namespace == other.Namespace &&
+		b.ClusterRole == other.ClusterRole &&
+		reflect.DeepEqual(b.Rules, other.Rules)
+}
+
+// legacyBinding reports whether the identity is granted through the binding clusters got before
+// the bootstrap identity was configurable, which is replaced by a binding of the driver
+func (b bootstrapIdentity) legacyBinding() bool {
+	return b.Binding != "" && b.Binding != b.bindingName()
+}
+
+// roleName is the ClusterRole bound to the service account
+func (b bootstrapIdentity) roleName() string {
+	if len(b.Rules) > 0 {
+		return managedRolePrefix + b.Namespace + ":" + b.ServiceAccount
+	}
+	return b.ClusterRole
+}
+
+// bindingName is the ClusterRoleBinding MyDriver creates for the identity
+func (b bootstrapIdentity) bindingName() string {
+	return managedRolePrefix + b.Namespace + ":" + b.ServiceAccount
+}
+
+// newBootstrapIdentity builds the identity from the create options
+func newBootstrapIdentity(config driverConfig) bootstrapIdentity {
+	identity := bootstrapIdentity{
+		ServiceAccount: config.ServiceAccountName,
+		Namespace:      config.ServiceAccountNamespace,
+		ClusterRole:    config.ClusterRole,
+		Rules:          config.ClusterRoleRules,
+	}
+	if identity.ClusterRole == "" && len(identity.Rules) == 0 {
+		identity.ClusterRole = defaultBootstrapClusterRole
+	}
+	return identity
+}
+
+// updatedBootstrapIdentity applies the update options to identity, giving a cluster role replaces
+// the rules and the other way around
+func updatedBootstrapIdentity(config driverConfig, identity bootstrapIdentity) bootstrapIdentity {
+	if config.ServiceAccountName != "" {
+		identity.ServiceAccount = config.ServiceAccountName
+	}
+	if config.ServiceAccountNamespace != "" {
+		identity.Namespace = config.ServiceAccountNamespace
+	}
+	if config.ClusterRole != "" {
+		identity.ClusterRole = config.ClusterRole
+		identity.Rules = nil
+	}
+	if len(config.ClusterRoleRules) > 0 {
+		identity.ClusterRole = ""
+		identity.Rules = config.ClusterRoleRules
+	}
+	return identity
+}
+
+// parsePolicyRule parses the cluster-role-rules option format, a comma separated list of
+// key=value pairs where every key may repeat, such as "verb=get,verb=list,group=,resource=nodes"
+func parsePolicyRule(spec string) (rbacv1.PolicyRule, error) {
+	rule := rbacv1.PolicyRule{}
+	for _, field := range strings.Split(spec, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		kv := strings.SplitN(field, "=", 2)
+		if len(kv) != 2 {
+			return rule, fmt.Errorf("rule %q: %q is not of the form key=value", spec, field)
+		}
+		switch kv[0] {
+		case "verb":
+			rule.Verbs = append(rule.Verbs, kv[1])
+		case "group":
+			rule.APIGroups = append(rule.APIGroups, kv[1])
+		case "resource":
+			rule.Resources = append(rule.Resources, kv[1])
+		case "name":
+			rule.ResourceNames = append(rule.ResourceNames, kv[1])
+		case "url":
+			rule.NonResourceURLs = append(rule.NonResourceURLs, kv[1])
+		default:
+			return rule, fmt.Errorf("rule %q: unknown key %q", spec, kv[0])
+		}
+	}
+
+	switch {
+	case len(rule.Verbs) == 0:
+		return rule, fmt.Errorf("rule %q: at least one verb is required", spec)
+	case len(rule.NonResourceURLs) > 0 && (len(rule.Resources) > 0 || len(rule.APIGroups) > 0):
+		return rule, fmt.Errorf("rule %q: url cannot be combined with group or resource", spec)
+	case len(rule.NonResourceURLs) == 0 && len(rule.Resources) == 0:
+		return rule, fmt.Errorf("rule %q: either a resource or a url is required", spec)
+	}
+	return rule, nil
+}
+
+// serviceAccountToken creates the bootstrap identity of the cluster, waits for the token
+// controller to mint the token of its service account and returns the token along with the
+// identity as created
+func (m *MyDriver) serviceAccountToken(ctx context.Context, state state, clientset kubernetes.Interface) (string, bootstrapIdentity, error) {
+	identity := state.Bootstrap
+	serviceAccount := &v1.ServiceAccount{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: identity.ServiceAccount,
+		},
+	}
+	if _, err := clientset.CoreV1().ServiceAccounts(identity.Namespace).Create(serviceAccount); err != nil && !errors.IsAlreadyExists(err) {
+		return "", identity, fmt.Errorf("error creating service account: %v", err)
+	}
+
+	if err := ensureClusterRole(clientset, identity); err != nil {
+		return "", identity, err
+	}
+
+	binding := &rbacv1.ClusterRoleBinding{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: identity.bindingName(),
+		},
+		Subjects: []rbacv1.Subject{
+			{
+				Kind:      rbacv1.ServiceAccountKind,
+				Name:      identity.ServiceAccount,
+				Namespace: identity.Namespace,
+			},
+		},
+		RoleRef: rbacv1.RoleRef{
+			Kind:     "ClusterRole",
+			Name:     identity.roleName(),
+			APIGroup: rbacv1.GroupName,
+		},
+	}
+	if _, err := clientset.RbacV1().ClusterRoleBindings().Create(binding); errors.IsAlreadyExists(err) {
+		// the role of a binding cannot change, replace it
+		if err := clientset.RbacV1().ClusterRoleBindings().Delete(binding.Name, &metav1.DeleteOptions{}); err != nil && !errors.IsNotFound(err) {
+			return "", identity, fmt.Errorf("error replacing role binding: %v", err)
+		}
+		if _, err := clientset.RbacV1().ClusterRoleBindings().Create(binding); err != nil {
+			return "", identity, fmt.Errorf("error replacing role binding: %v", err)
+		}
+	} else if err != nil {
+		return "", identity, fmt.Errorf("error creating role binding: %v", err)
+	}
+	identity.Binding = binding.Name
+
+	var token string
+	description := fmt.Sprintf("the token of service account %s/%s", identity.Namespace, identity.ServiceAccount)
+	err := waitFor(ctx, m.backoff, state.Name, description, func(ctx context.Context) (bool, error) {
+		var err error
+		token, err = secretToken(clientset, identity.Namespace, identity.ServiceAccount)
+		return token != "", err
+	})
+	if err != nil {
+		return "", identity, fmt.Errorf("error getting service account token: %v", err)
+	}
+	return token, identity, nil
+}
+
+// ensureClusterRole makes sure the role of the identity exists. A named role has to exist
+// already, the driver never creates roles broader than the rules it was given.
+func ensureClusterRole(clientset kubernetes.Interface, identity bootstrapIdentity) error {
+	if len(identity.Rules) == 0 {
+		if _, err := clientset.RbacV1().ClusterRoles().Get(identity.ClusterRole, metav1.GetOptions{}); errors.IsNotFound(err) {
+			return fmt.Errorf("cluster role %s does not exist", identity.ClusterRole)
+		} else if err != nil {
+			return fmt.Errorf("error getting cluster role %s: %v", identity.ClusterRole, err)
+		}
+		return nil
+	}
+
+	role := &rbacv1.ClusterRole{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: identity.roleName(),
+		},
+	}
+	for _, spec := range identity.Rules {
+		rule, err := parsePolicyRule(spec)
+		if err != nil {
+			return err
+		}
+		role.Rules = append(role.Rules, rule)
+	}
+
+	existing, err := clientset.RbacV1().ClusterRoles().Get(role.Name, metav1.GetOptions{})
+	switch {
+	case errors.IsNotFound(err):
+		if _, err := clientset.RbacV1().ClusterRoles().Create(role); err != nil {
+			return fmt.Errorf("error creating cluster role %s: %v", role.Name, err)
+		}
+	case err != nil:
+		return fmt.Errorf("error getting cluster role %s: %v", role.Name, err)
+	default:
+		existing.Rules = role.Rules
+		if _, err := clientset.RbacV1().ClusterRoles().Update(existing); err != nil {
+			return fmt.Errorf("error updating cluster role %s: %v", role.Name, err)
+		}
+	}
+	return nil
+}
+
+// replaceBootstrapIdentity creates identity, switches the cluster info over to its token and
+// then revokes the identity it replaces
+func (m *MyDriver) replaceBootstrapIdentity(ctx context.Context, state *state, info *types.ClusterInfo, identity bootstrapIdentity) error {
+	clientset, err := m.clientset(info)
+	if err != nil {
+		return err
+	}
+
+	log := clusterLog(ctx, state.Name)
+	log.Infof("changing the service account of cluster %s to %s/%s", state.Name, identity.Namespace, identity.ServiceAccount)
+	previous := state.Bootstrap
+	next := *state
+	next.Bootstrap = identity
+	token, identity, err := m.serviceAccountToken(ctx, next, clientset)
+	if err != nil {
+		return err
+	}
+	state.Bootstrap = identity
+	info.ServiceAccountToken = token
+
+	log.Infof("revoking the previous service account %s/%s of cluster %s", previous.Namespace, previous.ServiceAccount, state.Name)
+	return revokeBootstrapIdentity(clientset, previous, identity)
+}
+
+// revokeBootstrapIdentity removes what previous granted and current does not share with it: the
+// binding, the role the driver managed for it and the service account along with its token
+func revokeBootstrapIdentity(clientset kubernetes.Interface, previous, current bootstrapIdentity) error {
+	if previous.Binding != "" && previous.Binding != current.Binding {
+		if err := clientset.RbacV1().ClusterRoleBindings().Delete(previous.Binding, &metav1.DeleteOptions{}); err != nil && !errors.IsNotFound(err) {
+			return fmt.Errorf("error deleting role binding %s: %v", previous.Binding, err)
+		}
+	}
+	if len(previous.Rules) > 0 && previous.roleName() != current.roleName() {
+		if err := clientset.RbacV1().ClusterRoles().Delete(previous.roleName(), &metav1.DeleteOptions{}); err != nil && !errors.IsNotFound(err) {
+			return fmt.Errorf("error deleting cluster role %s: %v", previous.roleName(), err)
+		}
+	}
+	if previous.ServiceAccount != current.ServiceAccount || previous.Namespace != current.Namespace {
+		if err := clientset.CoreV1().ServiceAccounts(previous.Namespace).Delete(previous.ServiceAccount, &metav1.DeleteOptions{}); err != nil && !errors.IsNotFound(err) {
+			return fmt.Errorf("error deleting service account %s/%s: %v", previous.Namespace, previous.ServiceAccount, err)
+		}
+	}
+	return nil
+}
+
+// secretToken returns the token of a service account, or an empty token while the token
+// controller has not minted it yet
+func secretToken(clientset kubernetes.Interface, namespace, name string) (string, error) {
+	serviceAccount, err := clientset.CoreV1().ServiceAccounts(namespace).Get(name, metav1.GetOptions{})
+	if err != nil {
+		return "", fmt.Errorf("error getting service account: %v", err)
+	}
+	for _, ref := range serviceAccount.Secrets {
+		secret, err := clientset.CoreV1().Secrets(namespace).Get(ref.Name, metav1.GetOptions{})
+		if err != nil {
+			return "", fmt.Errorf("error getting secret: %v", err)
+		}
+		if token, ok := secret.Data[v1.ServiceAccountTokenKey]; ok {
+			return string(token), nil
+		}
+	}
+	return "", nil
+}
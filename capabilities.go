@@ -0,0 +1,38 @@
+package main
+
+import (
+	"github.com/rancher/kontainer-engine/types"
+)
+
+// backendCapabilities maps every driver capability to the check that tells whether a backend provides it
+var backendCapabilities = map[int64]func(clusterBackend) bool{
+	types.GetVersionCapability: func(b clusterBackend) bool {
+		_, ok := b.(versionReader)
+		return ok
+	},
+	types.SetVersionCapability: func(b clusterBackend) bool {
+		_, ok := b.(versionWriter)
+		return ok
+	},
+	types.GetClusterSizeCapability: func(b clusterBackend) bool {
+		_, ok := b.(sizeReader)
+		return ok
+	},
+	types.SetClusterSizeCapability: func(b clusterBackend) bool {
+		_, ok := b.(sizeWriter)
+		return ok
+	},
+}
+
+// capabilitiesOf computes the capabilities of a backend from the interfaces it implements
+func capabilitiesOf(backend clusterBackend) *types.Capabilities {
+	capabilities := &types.Capabilities{
+		Capabilities: make(map[int64]bool),
+	}
+	for capability, provided := range backendCapabilities {
+		if provided(backend) {
+			capabilities.AddCapability(capability)
+		}
+	}
+	return capabilities
+}
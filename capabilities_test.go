@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/rancher/kontainer-engine/types"
+)
+
+// bareBackend only provisions and removes clusters
+type bareBackend struct{}
+
+func (bareBackend) Name() string { return "bare" }
+
+func (bareBackend) CreateSteps() []createStep { return nil }
+
+func (bareBackend) Remove(ctx context.Context, state *state) error { return nil }
+
+type versionedBackend struct{ bareBackend }
+
+func (versionedBackend) GetVersion(ctx context.Context, state *state) (string, error) { return "", nil }
+
+func (versionedBackend) SetVersion(ctx context.Context, state *state, version string) error {
+	return nil
+}
+
+type readOnlyBackend struct{ bareBackend }
+
+func (readOnlyBackend) GetVersion(ctx context.Context, state *state) (string, error) { return "", nil }
+
+func (readOnlyBackend) GetClusterSize(ctx context.Context, state *state) (int64, error) {
+	return 0, nil
+}
+
+type sizedBackend struct{ bareBackend }
+
+func (sizedBackend) GetClusterSize(ctx context.Context, state *state) (int64, error) { return 0, nil }
+
+func (sizedBackend) GetNodePoolSizes(ctx context.Context, state *state) (map[string]int64, error) {
+	return nil, nil
+}
+
+func (sizedBackend) SetNodePools(ctx context.Context, state *state) error { return nil }
+
+func TestCapabilitiesOf(t *testing.T) {
+	tests := []struct {
+		name    string
+		backend clusterBackend
+		want    []int64
+	}{
+		{name: "none", backend: bareBackend{}},
+		{
+			name:    "version",
+			backend: versionedBackend{},
+			want:    []int64{types.GetVersionCapability, types.SetVersionCapability},
+		},
+		{
+			name:    "read only",
+			backend: readOnlyBackend{},
+			want:    []int64{types.GetVersionCapability, types.GetClusterSizeCapability},
+		},
+		{
+			name:    "cluster size",
+			backend: sizedBackend{},
+			want:    []int64{types.GetClusterSizeCapability, types.SetClusterSizeCapability},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var got []int64
+			for capability, ok := range capabilitiesOf(test.backend).Capabilities {
+				if ok {
+					got = append(got, capability)
+				}
+			}
+			sort.Slice(got, func(i, j int) bool { return got[i] < got[j] })
+			sort.Slice(test.want, func(i, j int) bool { return test.want[i] < test.want[j] })
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("capabilities are %v, want %v", got, test.want)
+			}
+		})
+	}
+}
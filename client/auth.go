@@ -0,0 +1,28 @@
+package client
+
+import (
+	"context"
+)
+
+const (
+	// AuthorizationKey is the metadata key of the bearer token, it travels next to the log-id
+	// key types.GetCtx reads
+	AuthorizationKey = "authorization"
+	// BearerPrefix precedes the token in the authorization metadata
+	BearerPrefix = "Bearer "
+)
+
+// tokenCredentials attach the bearer token to every RPC
+type tokenCredentials struct {
+	token string
+}
+
+func (c tokenCredentials) GetRequestMetadata(ctx context.Context, uri ...string) (map[string]string, error) {
+	return map[string]string{AuthorizationKey: BearerPrefix + c.token}, nil
+}
+
+// RequireTransportSecurity allows the token over plaintext connections, the driver listens on
+// the loopback interface unless told otherwise
+func (c tokenCredentials) RequireTransportSecurity() bool {
+	return false
+}
@@ -0,0 +1,184 @@
+// Package client connects to a driver served by the mydriver binary. It does what
+// types.NewClient and cluster.NewCluster do, which always dial without transport security, but
+// takes Options to reach a driver that requires mutual TLS or a bearer token.
+//
+//	handshake, err := client.ParseHandshake(line)
+//	...
+//	c, err := client.NewCluster(handshake.Driver, handshake.Target(), name, configGetter, store, handshake.Options())
+//
+// Addresses are host:port or, for a driver listening on a Unix socket, unix:///path/to/socket.
+// service.RegisterExternalDriver takes the same addresses, but types.NewClient dials without
+// credentials, so a driver that requires mutual TLS or a token is reached through NewClient,
+// NewCluster or FromCluster.
+package client
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/rancher/kontainer-engine/cluster"
+	"github.com/rancher/kontainer-engine/types"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/status"
+)
+
+// Options control how a client connects to a driver
+type Options struct {
+	// TLS secures the connection, nil connects without transport security. A driver serving
+	// mutual TLS needs a config with a client certificate, see TLSConfig.
+	TLS *tls.Config
+	// Token is attached to every RPC as a bearer token, for a driver started with MYDRIVER_TOKEN
+	// or --token-file
+	Token string
+}
+
+// unixScheme prefixes the address of a Unix socket
+const unixScheme = "unix://"
+
+func (o Options) dialOptions(addr string) []grpc.DialOption {
+	dialOptions := []grpc.DialOption{grpc.WithInsecure()}
+	if o.TLS != nil {
+		config := o.TLS
+		if strings.HasPrefix(addr, unixScheme) && config.ServerName == "" {
+			// grpc takes the server name from the address, a socket path is no host name
+			config = config.Clone()
+			config.ServerName = "localhost"
+		}
+		dialOptions = []grpc.DialOption{grpc.WithTransportCredentials(credentials.NewTLS(config))}
+	}
+	if path := strings.TrimPrefix(addr, unixScheme); path != addr {
+		dialOptions = append(dialOptions, grpc.WithDialer(func(_ string, timeout time.Duration) (net.Conn, error) {
+			return net.DialTimeout("unix", path, timeout)
+		}))
+	}
+	if o.Token != "" {
+		dialOptions = append(dialOptions, grpc.WithPerRPCCredentials(tokenCredentials{token: o.Token}))
+	}
+	return dialOptions
+}
+
+// NewClient creates a grpc client for a driver plugin
+func NewClient(driverName, addr string, opts Options) (types.Driver, error) {
+	conn, err := grpc.Dial(addr, opts.dialOptions(addr)...)
+	if err != nil {
+		return nil, err
+	}
+	return &grpcClient{
+		client:     types.NewDriverClient(conn),
+		driverName: driverName,
+	}, nil
+}
+
+// NewCluster is cluster.NewCluster connecting with opts
+func NewCluster(driverName, addr, name string, configGetter cluster.ConfigGetter, persistStore cluster.PersistentStore, opts Options) (*cluster.Cluster, error) {
+	rpcClient, err := NewClient(driverName, addr, opts)
+	if err != nil {
+		return nil, err
+	}
+	return &cluster.Cluster{
+		Driver:       rpcClient,
+		DriverName:   driverName,
+		Name:         name,
+		ConfigGetter: configGetter,
+		PersistStore: persistStore,
+	}, nil
+}
+
+// FromCluster is cluster.FromCluster connecting with opts
+func FromCluster(c *cluster.Cluster, addr string, configGetter cluster.ConfigGetter, persistStore cluster.PersistentStore, opts Options) (*cluster.Cluster, error) {
+	rpcClient, err := NewClient(c.DriverName, addr, opts)
+	if err != nil {
+		return nil, err
+	}
+	c.Driver = rpcClient
+	c.ConfigGetter = configGetter
+	c.PersistStore = persistStore
+	return c, nil
+}
+
+// grpcClient implements types.Driver over a connection to a driver, the same way the client of
+// types.NewClient does
+type grpcClient struct {
+	client     types.DriverClient
+	driverName string
+}
+
+func (rpc *grpcClient) Create(ctx context.Context, opts *types.DriverOptions, clusterInfo *types.ClusterInfo) (*types.ClusterInfo, error) {
+	o, err := rpc.client.Create(ctx, &types.CreateRequest{
+		DriverOptions: opts,
+		ClusterInfo:   clusterInfo,
+	})
+	err = handleErr(err)
+	if err == nil && o.CreateError != "" {
+		err = errors.New(o.CreateError)
+	}
+	return o, err
+}
+
+func (rpc *grpcClient) Update(ctx context.Context, clusterInfo *types.ClusterInfo, opts *types.DriverOptions) (*types.ClusterInfo, error) {
+	o, err := rpc.client.Update(ctx, &types.UpdateRequest{
+		ClusterInfo:   clusterInfo,
+		DriverOptions: opts,
+	})
+	return o, handleErr(err)
+}
+
+func (rpc *grpcClient) PostCheck(ctx context.Context, clusterInfo *types.ClusterInfo) (*types.ClusterInfo, error) {
+	o, err := rpc.client.PostCheck(ctx, clusterInfo)
+	return o, handleErr(err)
+}
+
+func (rpc *grpcClient) Remove(ctx context.Context, clusterInfo *types.ClusterInfo) error {
+	_, err := rpc.client.Remove(ctx, clusterInfo)
+	return handleErr(err)
+}
+
+func (rpc *grpcClient) GetDriverCreateOptions(ctx context.Context) (*types.DriverFlags, error) {
+	o, err := rpc.client.GetDriverCreateOptions(ctx, &types.Empty{})
+	return o, handleErr(err)
+}
+
+func (rpc *grpcClient) GetDriverUpdateOptions(ctx context.Context) (*types.DriverFlags, error) {
+	o, err := rpc.client.GetDriverUpdateOptions(ctx, &types.Empty{})
+	return o, handleErr(err)
+}
+
+func (rpc *grpcClient) GetVersion(ctx context.Context, info *types.ClusterInfo) (*types.KubernetesVersion, error) {
+	version, err := rpc.client.GetVersion(ctx, info)
+	return version, handleErr(err)
+}
+
+func (rpc *grpcClient) SetVersion(ctx context.Context, info *types.ClusterInfo, version *types.KubernetesVersion) error {
+	_, err := rpc.client.SetVersion(ctx, &types.SetVersionRequest{Info: info, Version: version})
+	return handleErr(err)
+}
+
+func (rpc *grpcClient) GetClusterSize(ctx context.Context, info *types.ClusterInfo) (*types.NodeCount, error) {
+	size, err := rpc.client.GetNodeCount(ctx, info)
+	return size, handleErr(err)
+}
+
+func (rpc *grpcClient) SetClusterSize(ctx context.Context, info *types.ClusterInfo, count *types.NodeCount) error {
+	_, err := rpc.client.SetNodeCount(ctx, &types.SetNodeCountRequest{Info: info, Count: count})
+	return handleErr(err)
+}
+
+func (rpc *grpcClient) GetCapabilities(ctx context.Context) (*types.Capabilities, error) {
+	return rpc.client.GetCapabilities(ctx, &types.Empty{})
+}
+
+// handleErr unwraps errors the driver returned without a status code, like types.NewClient
+func handleErr(err error) error {
+	if st, ok := status.FromError(err); ok {
+		if st.Code() == codes.Unknown && st.Message() != "" {
+			return errors.New(st.Message())
+		}
+	}
+	return err
+}
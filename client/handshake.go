@@ -0,0 +1,107 @@
+package client
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// HandshakeVersion is the version of the handshake line the driver writes to stdout
+const HandshakeVersion = 2
+
+// The transports a driver announces in its handshake
+const (
+	// TransportPlaintext is gRPC without transport security
+	TransportPlaintext = "plaintext"
+	// TransportMTLS is gRPC over TLS, the driver requires a client certificate
+	TransportMTLS = "mtls"
+)
+
+// Handshake is the line a driver writes to stdout once it listens:
+//
+//	VERSION|NETWORK|ADDRESS|DRIVER|TRANSPORT|CREDENTIALS
+//	2|tcp|127.0.0.1:41235|mydriver|plaintext|
+//	2|unix|/var/run/mydriver.sock|mydriver|plaintext|
+//
+// TRANSPORT is TransportPlaintext or TransportMTLS. CREDENTIALS is empty unless the driver
+// generated an ephemeral CA, then it is the base64 encoded PEM of the CA certificate, a client
+// certificate signed by it and the key of that certificate. Only the launching process can read
+// them, it is the only one able to connect.
+type Handshake struct {
+	Version   int
+	Network   string
+	Address   string
+	Driver    string
+	Transport string
+	// Credentials are the PEM blocks of the CREDENTIALS field
+	Credentials []byte
+}
+
+// String formats the handshake line, without the trailing newline
+func (h Handshake) String() string {
+	return strings.Join([]string{
+		strconv.Itoa(h.Version),
+		h.Network,
+		h.Address,
+		h.Driver,
+		h.Transport,
+		base64.StdEncoding.EncodeToString(h.Credentials),
+	}, "|")
+}
+
+// ParseHandshake parses the handshake line of a driver
+func ParseHandshake(line string) (Handshake, error) {
+	fields := strings.Split(strings.TrimSpace(line), "|")
+	version, err := strconv.Atoi(fields[0])
+	if err != nil {
+		return Handshake{}, fmt.Errorf("invalid handshake %q: no version", line)
+	}
+	if version != HandshakeVersion {
+		return Handshake{}, fmt.Errorf("unsupported handshake version %d, expected %d", version, HandshakeVersion)
+	}
+	if len(fields) != 6 {
+		return Handshake{}, fmt.Errorf("invalid handshake %q: expected 6 fields, got %d", line, len(fields))
+	}
+
+	h := Handshake{
+		Version:   version,
+		Network:   fields[1],
+		Address:   fields[2],
+		Driver:    fields[3],
+		Transport: fields[4],
+	}
+	switch h.Transport {
+	case TransportPlaintext, TransportMTLS:
+	default:
+		return Handshake{}, fmt.Errorf("invalid handshake %q: unknown transport %q", line, h.Transport)
+	}
+	if h.Credentials, err = base64.StdEncoding.DecodeString(fields[5]); err != nil {
+		return Handshake{}, fmt.Errorf("invalid handshake credentials: %v", err)
+	}
+	if len(h.Credentials) > 0 {
+		if _, err := credentialsTLSConfig(h.Credentials); err != nil {
+			return Handshake{}, err
+		}
+	}
+	return h, nil
+}
+
+// Options returns the options to connect to the driver. A driver serving mutual TLS with
+// certificates of its own hands out no credentials, set Options.TLS with TLSConfig then.
+func (h Handshake) Options() Options {
+	if h.Transport != TransportMTLS || len(h.Credentials) == 0 {
+		return Options{}
+	}
+	config, _ := credentialsTLSConfig(h.Credentials)
+	return Options{TLS: config}
+}
+
+// Target returns the address to pass to NewClient, a unix:// address for a driver listening on
+// a Unix socket
+func (h Handshake) Target() string {
+	if h.Network == "unix" {
+		return unixScheme + h.Address
+	}
+	return h.Address
+}
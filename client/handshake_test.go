@@ -0,0 +1,81 @@
+package client
+
+import (
+	"encoding/base64"
+	"strings"
+	"testing"
+)
+
+func TestParseHandshake(t *testing.T) {
+	tests := []struct {
+		name    string
+		line    string
+		want    Handshake
+		wantErr string
+	}{
+		{
+			name: "plaintext",
+			line: "2|tcp|127.0.0.1:41235|mydriver|plaintext|\n",
+			want: Handshake{Version: 2, Network: "tcp", Address: "127.0.0.1:41235", Driver: "mydriver", Transport: TransportPlaintext, Credentials: []byte{}},
+		},
+		{
+			name: "mutual tls without credentials",
+			line: "2|tcp|127.0.0.1:41235|mydriver|mtls|",
+			want: Handshake{Version: 2, Network: "tcp", Address: "127.0.0.1:41235", Driver: "mydriver", Transport: TransportMTLS, Credentials: []byte{}},
+		},
+		{
+			name:    "no version",
+			line:    "tcp|127.0.0.1:41235|mydriver",
+			wantErr: `invalid handshake "tcp|127.0.0.1:41235|mydriver": no version`,
+		},
+		{
+			name:    "other version",
+			line:    "1|tcp|127.0.0.1:41235|mydriver",
+			wantErr: "unsupported handshake version 1, expected 2",
+		},
+		{
+			name:    "missing fields",
+			line:    "2|tcp|127.0.0.1:41235|mydriver",
+			wantErr: `invalid handshake "2|tcp|127.0.0.1:41235|mydriver": expected 6 fields, got 4`,
+		},
+		{
+			name:    "unknown transport",
+			line:    "2|tcp|127.0.0.1:41235|mydriver|tls|",
+			wantErr: `invalid handshake "2|tcp|127.0.0.1:41235|mydriver|tls|": unknown transport "tls"`,
+		},
+		{
+			name:    "credentials not base64",
+			line:    "2|tcp|127.0.0.1:41235|mydriver|mtls|!",
+			wantErr: "invalid handshake credentials: illegal base64 data at input byte 0",
+		},
+		{
+			name:    "credentials not PEM",
+			line:    "2|tcp|127.0.0.1:41235|mydriver|mtls|" + base64.StdEncoding.EncodeToString([]byte("secret")),
+			wantErr: "invalid handshake credentials: expected 3 PEM blocks, got 0",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := ParseHandshake(test.line)
+			if test.wantErr != "" {
+				if err == nil || err.Error() != test.wantErr {
+					t.Fatalf("error is %v, want %q", err, test.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.String() != test.want.String() {
+				t.Errorf("handshake is %q, want %q", got, test.want)
+			}
+			if got.String() != strings.TrimSpace(test.line) {
+				t.Errorf("handshake formats as %q, want the line it was parsed from", got)
+			}
+			if got.Options().TLS != nil {
+				t.Error("a handshake without credentials gives TLS options")
+			}
+		})
+	}
+}
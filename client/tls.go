@@ -0,0 +1,65 @@
+package client
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+)
+
+// TLSConfig builds the config to connect to a driver serving mutual TLS, certFile and keyFile
+// are the client certificate and caFile the CA the serving certificate of the driver is checked
+// against
+func TLSConfig(certFile, keyFile, caFile string) (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("error loading client certificate: %v", err)
+	}
+	caPEM, err := ioutil.ReadFile(caFile)
+	if err != nil {
+		return nil, fmt.Errorf("error reading ca certificate: %v", err)
+	}
+	roots := x509.NewCertPool()
+	if !roots.AppendCertsFromPEM(caPEM) {
+		return nil, fmt.Errorf("no certificates found in %s", caFile)
+	}
+	return &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		RootCAs:      roots,
+		MinVersion:   tls.VersionTLS12,
+	}, nil
+}
+
+// credentialsTLSConfig builds the config from the CREDENTIALS of a handshake: the CA
+// certificate, then the client certificate and its key
+func credentialsTLSConfig(credentials []byte) (*tls.Config, error) {
+	var blocks []*pem.Block
+	for rest := credentials; ; {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		blocks = append(blocks, block)
+	}
+	if len(blocks) != 3 {
+		return nil, fmt.Errorf("invalid handshake credentials: expected 3 PEM blocks, got %d", len(blocks))
+	}
+
+	ca, err := x509.ParseCertificate(blocks[0].Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("invalid handshake credentials: error parsing ca certificate: %v", err)
+	}
+	cert, err := tls.X509KeyPair(pem.EncodeToMemory(blocks[1]), pem.EncodeToMemory(blocks[2]))
+	if err != nil {
+		return nil, fmt.Errorf("invalid handshake credentials: %v", err)
+	}
+	roots := x509.NewCertPool()
+	roots.AddCert(ca)
+	return &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		RootCAs:      roots,
+		MinVersion:   tls.VersionTLS12,
+	}, nil
+}
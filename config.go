@@ -0,0 +1,147 @@
+package main
+
+import (
+	"time"
+
+	"github.com/rancher/example-kontainer-engine-driver/schema"
+	"github.com/rancher/kontainer-engine/types"
+)
+
+const (
+	// defaultClusterCIDR and defaultServiceCIDR match the defaults of the cluster-cidr and
+	// service-cidr options
+	defaultClusterCIDR = "10.42.0.0/16"
+	defaultServiceCIDR = "10.43.0.0/16"
+
+	// the defaults of the create-timeout, update-timeout and remove-timeout options
+	defaultCreateTimeout = 30 * time.Minute
+	defaultUpdateTimeout = 30 * time.Minute
+	defaultRemoveTimeout = 10 * time.Minute
+)
+
+// driverConfig declares every option MyDriver understands. The update tag tells how an
+// option behaves on update: mutable, update-only or immutable, the default. Every immutable
+// option needs a check in immutableOptionChecks.
+type driverConfig struct {
+	Name                    string        `option:"name,required" format:"dns-label" update:"immutable" usage:"The internal name of the cluster in Rancher"`
+	DisplayName             string        `option:"display-name,alias=displayName" update:"mutable" usage:"The name of the cluster that should be displayed to the user"`
+	KubernetesVersion       string        `option:"kubernetes-version,alias=kubernetesVersion" default:"v1.10.5" update:"mutable" usage:"The kubernetes version of the cluster"`
+	NodeCount               int64         `option:"node-count,alias=nodeCount" default:"3" min:"0" max:"1000" update:"mutable" usage:"The number of nodes of the default node pool, ignored when node pools are given"`
+	NodePools               []string      `option:"node-pools,alias=nodePools" update:"immutable" usage:"The node pools of the cluster, each of the form name=NAME,count=N[,min=N][,max=N][,label=KEY=VALUE...][,taint=KEY=VALUE:EFFECT...]"`
+	ResizePolicy            string        `option:"resize-policy,alias=resizePolicy" default:"last" enum:"last,first,largest,smallest" update:"mutable" usage:"The node pool that absorbs a cluster size change, one of last, first, largest or smallest"`
+	ResizePool              string        `option:"resize-pool,alias=resizePool" update:"mutable" usage:"The name of the node pool that absorbs cluster size changes, overrides resize-policy"`
+	ClusterCIDR             string        `option:"cluster-cidr,alias=clusterCidr" default:"10.42.0.0/16" format:"cidr" update:"immutable" usage:"The IP address range of the pods"`
+	ServiceCIDR             string        `option:"service-cidr,alias=serviceCidr" default:"10.43.0.0/16" format:"cidr" update:"immutable" usage:"The IP address range of the services"`
+	RollbackOnFailure       bool          `option:"rollback-on-failure,alias=rollbackOnFailure" update:"immutable" usage:"Undo the completed create steps when a later step fails instead of leaving them for a retry"`
+	CreateTimeout           time.Duration `option:"create-timeout,alias=createTimeout" default:"30m" min:"0s" update:"immutable" usage:"How long creating the cluster may take, 0 waits forever"`
+	UpdateTimeout           time.Duration `option:"update-timeout,alias=updateTimeout" default:"30m" min:"0s" update:"mutable" usage:"How long an update, upgrade or resize of the cluster may take, 0 waits forever"`
+	RemoveTimeout           time.Duration `option:"remove-timeout,alias=removeTimeout" default:"10m" min:"0s" update:"mutable" usage:"How long removing the cluster may take, 0 waits forever"`
+	ServiceAccountName      string        `option:"service-account-name,alias=serviceAccountName" default:"netes-default" format:"dns-label" update:"mutable" usage:"The name of the service account Rancher manages the cluster with"`
+	ServiceAccountNamespace string        `option:"service-account-namespace,alias=serviceAccountNamespace" default:"default" format:"dns-label" update:"mutable" usage:"The namespace of the service account Rancher manages the cluster with"`
+	ClusterRole             string        `option:"cluster-role,alias=clusterRole" update:"mutable" usage:"The existing ClusterRole granted to the service account, cluster-admin unless cluster-role-rules are given"`
+	ClusterRoleRules        []string      `option:"cluster-role-rules,alias=clusterRoleRules" update:"mutable" usage:"The rules of a ClusterRole the driver manages for the service account instead of granting cluster-role, each of the form verb=VERB[,verb=VERB...][,group=GROUP...][,resource=RESOURCE...][,name=NAME...][,url=URL...]"`
+	PoolSizes               []string      `option:"pool-sizes,alias=poolSizes" update:"update-only" usage:"The new size of individual node pools, each of the form NAME=COUNT"`
+}
+
+// configFields are the options declared by driverConfig
+var configFields = mustFields(driverConfig{})
+
+func mustFields(v interface{}) []schema.Field {
+	fields, err := schema.Fields(v)
+	if err != nil {
+		panic(err)
+	}
+	return fields
+}
+
+// optionSet reports whether opts give a value for the named option
+func optionSet(opts *types.DriverOptions, name string) bool {
+	for _, field := range configFields {
+		if field.Name == name {
+			return schema.IsSet(opts, field)
+		}
+	}
+	return false
+}
+
+// updateMode returns how an option behaves on update, options without an update tag are immutable
+func updateMode(field schema.Field) string {
+	if mode := field.Tag.Get("update"); mode != "" {
+		return mode
+	}
+	return optionImmutable
+}
+
+func getDriverCreateOptions() *types.DriverFlags {
+	var fields []schema.Field
+	for _, field := range configFields {
+		if updateMode(field) != optionUpdateOnly {
+			fields = append(fields, field)
+		}
+	}
+	return schema.FlagsFor(fields)
+}
+
+func getDriverUpdateOptions() *types.DriverFlags {
+	var fields []schema.Field
+	for _, field := range configFields {
+		if mode := updateMode(field); mode == optionMutable || mode == optionUpdateOnly {
+			// defaults only make sense on create, on update they would silently revert changes
+			field.Default = ""
+			fields = append(fields, field)
+		}
+	}
+	return schema.FlagsFor(fields)
+}
+
+// getCreateConfig decodes the create options, applying defaults
+func getCreateConfig(opts *types.DriverOptions) (driverConfig, error) {
+	config := driverConfig{}
+	return config, schema.Decode(opts, &config)
+}
+
+// getUpdateConfig decodes the update options, unset options keep their zero value
+func getUpdateConfig(opts *types.DriverOptions) (driverConfig, error) {
+	config := driverConfig{}
+	decoder := schema.Decoder{
+		SkipDefaults: true,
+		SkipRequired: true,
+	}
+	return config, decoder.Decode(opts, &config)
+}
+
+// getStateFromOpts builds the state of a new cluster, the options are validated first so that
+// nothing is provisioned from a bad config
+func getStateFromOpts(driverOptions *types.DriverOptions) (state, error) {
+	s := state{}
+	config, err := validateCreateConfig(driverOptions)
+	if err != nil {
+		return s, err
+	}
+
+	s.Name = config.Name
+	s.DisplayName = config.DisplayName
+	version, err := lookupVersion(config.KubernetesVersion)
+	if err != nil {
+		return s, err
+	}
+	s.KubernetesVersion = version.String()
+
+	s.NodePools, _ = validateNodePools(config.NodePools)
+	if len(s.NodePools) == 0 {
+		s.NodePools = []nodePool{{Name: defaultNodePoolName, Count: config.NodeCount}}
+	}
+
+	s.ResizePolicy = config.ResizePolicy
+	s.ResizePool = config.ResizePool
+	s.ClusterCIDR = config.ClusterCIDR
+	s.ServiceCIDR = config.ServiceCIDR
+	s.RollbackOnFailure = config.RollbackOnFailure
+	s.Timeouts = operationTimeouts{
+		Create: config.CreateTimeout,
+		Update: config.UpdateTimeout,
+		Remove: config.RemoveTimeout,
+	}
+	s.Bootstrap = newBootstrapIdentity(config)
+	return s, nil
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net"
+
+	"github.com/rancher/example-kontainer-engine-driver/client"
+)
+
+// writeHandshake tells the process that launched the driver where and how to reach it. Once the
+// listener is up the driver writes exactly one line to stdout, and nothing else goes there:
+//
+//	VERSION|NETWORK|ADDRESS|DRIVER|TRANSPORT|CREDENTIALS
+//	2|tcp|127.0.0.1:41235|mydriver|plaintext|
+//
+// VERSION is client.HandshakeVersion, NETWORK and ADDRESS are those of the listener, as given to
+// net.Dial, and DRIVER is the name to register the address under. TRANSPORT and CREDENTIALS are
+// described by client.Handshake. A parent that starts the driver on port 0 or on a Unix socket
+// reads the line with client.ParseHandshake and passes its Target to
+// service.RegisterExternalDriver, or to client.NewCluster along with its Options when the driver
+// requires mutual TLS or a token; logs go to stderr.
+func writeHandshake(w io.Writer, listener net.Listener, driverName string, secure bool, credentials []byte) error {
+	addr := listener.Addr()
+	handshake := client.Handshake{
+		Version:     client.HandshakeVersion,
+		Network:     addr.Network(),
+		Address:     addr.String(),
+		Driver:      driverName,
+		Transport:   client.TransportPlaintext,
+		Credentials: credentials,
+	}
+	if secure {
+		handshake.Transport = client.TransportMTLS
+	}
+	_, err := fmt.Fprintln(w, handshake)
+	return err
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/rancher/example-kontainer-engine-driver/client"
+)
+
+// failingWriter fails every write
+type failingWriter struct{}
+
+func (failingWriter) Write(p []byte) (int, error) { return 0, errors.New("stdout is closed") }
+
+func TestWriteHandshake(t *testing.T) {
+	_, credentials, err := ephemeralTLS("127.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name          string
+		secure        bool
+		credentials   []byte
+		wantTransport string
+	}{
+		{name: "plaintext", wantTransport: client.TransportPlaintext},
+		{name: "mutual tls with certificates of its own", secure: true, wantTransport: client.TransportMTLS},
+		{name: "ephemeral mutual tls", secure: true, credentials: credentials, wantTransport: client.TransportMTLS},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			listener, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer listener.Close()
+
+			var out bytes.Buffer
+			if err := writeHandshake(&out, listener, "mydriver", test.secure, test.credentials); err != nil {
+				t.Fatal(err)
+			}
+			line := out.String()
+			if strings.Count(line, "\n") != 1 || !strings.HasSuffix(line, "\n") {
+				t.Fatalf("handshake is %q, want exactly one line", line)
+			}
+
+			handshake, err := client.ParseHandshake(line)
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := client.Handshake{
+				Version:     client.HandshakeVersion,
+				Network:     "tcp",
+				Address:     listener.Addr().String(),
+				Driver:      "mydriver",
+				Transport:   test.wantTransport,
+				Credentials: test.credentials,
+			}
+			if handshake.String() != want.String() {
+				t.Errorf("handshake is %q, want %q", handshake, want)
+			}
+			if (handshake.Options().TLS != nil) != (test.credentials != nil) {
+				t.Errorf("the handshake options give TLS %v, want it only with credentials", handshake.Options().TLS)
+			}
+		})
+	}
+}
+
+func TestWriteHandshakeError(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	if err := writeHandshake(failingWriter{}, listener, "mydriver", false, nil); err == nil {
+		t.Error("writing the handshake to a closed stdout passed")
+	}
+}
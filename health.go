@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+const (
+	// driverService is the health service of the DriverServer, it reports the same as the server
+	driverService = "types.Driver"
+	// backendServicePrefix names the health service of a backend, such as mydriver.backend.simulated
+	backendServicePrefix = "mydriver.backend."
+	// healthCheckMethod is answered during startup and drain, without a token
+	healthCheckMethod = "/grpc.health.v1.Health/Check"
+
+	healthCheckInterval = 30 * time.Second
+	healthCheckTimeout  = 10 * time.Second
+)
+
+// healthCheck reports why a part of the driver cannot do its work, nil when it can
+type healthCheck func(ctx context.Context) error
+
+// healthChecks are the checks of the driver keyed by their health service
+func (m *MyDriver) healthChecks() map[string]healthCheck {
+	check := func(ctx context.Context) error { return nil }
+	if checker, ok := m.backend.(healthChecker); ok {
+		check = checker.CheckHealth
+	}
+	return map[string]healthCheck{backendServicePrefix + m.backend.Name(): check}
+}
+
+// healthServer implements the gRPC health service. Every check is a service of its own. The
+// server as a whole, the empty service name, and the DriverServer are SERVING once the driver
+// is ready and as long as every check passes, they are NOT_SERVING during startup and drain.
+type healthServer struct {
+	// Server keeps the status of the named services, its Check always reports SERVING for
+	// the server as a whole
+	*health.Server
+	checks map[string]healthCheck
+
+	mu      sync.Mutex
+	ready   bool
+	failing map[string]error
+	overall healthpb.HealthCheckResponse_ServingStatus
+}
+
+func newHealthServer(checks map[string]healthCheck) *healthServer {
+	h := &healthServer{
+		Server:  health.NewServer(),
+		checks:  checks,
+		failing: map[string]error{},
+	}
+	for name := range checks {
+		h.Server.SetServingStatus(name, healthpb.HealthCheckResponse_NOT_SERVING)
+	}
+	h.mu.Lock()
+	h.update()
+	h.mu.Unlock()
+	return h
+}
+
+// Check reports the status of a service, or of the server as a whole for the empty name
+func (h *healthServer) Check(ctx context.Context, in *healthpb.HealthCheckRequest) (*healthpb.HealthCheckResponse, error) {
+	if in.Service != "" {
+		return h.Server.Check(ctx, in)
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return &healthpb.HealthCheckResponse{Status: h.overall}, nil
+}
+
+// setReady marks the driver ready to provision, or not once it drains
+func (h *healthServer) setReady(ready bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.ready = ready
+	h.update()
+}
+
+// update derives the status of the server from readiness and the checks, the caller holds mu
+func (h *healthServer) update() {
+	h.overall = healthpb.HealthCheckResponse_NOT_SERVING
+	if h.ready && len(h.failing) == 0 {
+		h.overall = healthpb.HealthCheckResponse_SERVING
+	}
+	h.Server.SetServingStatus(driverService, h.overall)
+}
+
+// runChecks runs every check once, a check that does not return within healthCheckTimeout fails
+func (h *healthServer) runChecks(ctx context.Context) {
+	for name, check := range h.checks {
+		checkCtx, cancel := context.WithTimeout(ctx, healthCheckTimeout)
+		err := check(checkCtx)
+		cancel()
+		if ctx.Err() != nil {
+			return
+		}
+
+		h.mu.Lock()
+		_, wasFailing := h.failing[name]
+		if err != nil {
+			if !wasFailing {
+				logrus.Warnf("%s is unavailable: %v", name, err)
+			}
+			h.failing[name] = err
+			h.Server.SetServingStatus(name, healthpb.HealthCheckResponse_NOT_SERVING)
+		} else {
+			if wasFailing {
+				logrus.Infof("%s is available again", name)
+			}
+			delete(h.failing, name)
+			h.Server.SetServingStatus(name, healthpb.HealthCheckResponse_SERVING)
+		}
+		h.update()
+		h.mu.Unlock()
+	}
+}
+
+// monitor runs the checks every interval until ctx is done
+func (h *healthServer) monitor(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			h.runChecks(ctx)
+		}
+	}
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/rancher/kontainer-engine/types"
+	"google.golang.org/grpc"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// unhealthyBackend fails its health check
+type unhealthyBackend struct{ bareBackend }
+
+func (unhealthyBackend) CheckHealth(ctx context.Context) error { return errors.New("unreachable") }
+
+// health phases of the server under test
+const (
+	phaseStarting = "starting"
+	phaseReady    = "ready"
+	phaseDraining = "draining"
+)
+
+// probe checks the health of service on a new connection, the way a supervisor does
+func probe(t *testing.T, addr, service string) healthpb.HealthCheckResponse_ServingStatus {
+	t.Helper()
+	conn, err := grpc.Dial(addr, grpc.WithInsecure())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	resp, err := healthpb.NewHealthClient(conn).Check(context.Background(), &healthpb.HealthCheckRequest{Service: service})
+	if err != nil {
+		t.Fatalf("checking %q: %v", service, err)
+	}
+	return resp.Status
+}
+
+func TestHealth(t *testing.T) {
+	const (
+		serving    = healthpb.HealthCheckResponse_SERVING
+		notServing = healthpb.HealthCheckResponse_NOT_SERVING
+	)
+	tests := []struct {
+		name        string
+		backend     clusterBackend
+		token       string
+		phase       string
+		wantServer  healthpb.HealthCheckResponse_ServingStatus
+		wantBackend healthpb.HealthCheckResponse_ServingStatus
+	}{
+		{name: "starting", backend: bareBackend{}, phase: phaseStarting, wantServer: notServing, wantBackend: notServing},
+		{name: "ready", backend: bareBackend{}, phase: phaseReady, wantServer: serving, wantBackend: serving},
+		{name: "backend unavailable", backend: unhealthyBackend{}, phase: phaseReady, wantServer: notServing, wantBackend: notServing},
+		{name: "draining", backend: bareBackend{}, phase: phaseDraining, wantServer: notServing, wantBackend: serving},
+		{
+			name:        "health checks need no token",
+			backend:     bareBackend{},
+			token:       "s3cr3t",
+			phase:       phaseReady,
+			wantServer:  serving,
+			wantBackend: serving,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			m := &MyDriver{backend: test.backend}
+			driver := &blockingDriver{started: make(chan struct{}, 1), release: make(chan struct{})}
+			s := newRPCServer(driver, rpcServerOptions{Token: test.token, HealthChecks: m.healthChecks()})
+			addr := serveLocal(t, s)
+			if test.phase != phaseStarting {
+				s.MarkReady()
+			}
+
+			if test.phase == phaseDraining {
+				conn, err := grpc.Dial(addr, grpc.WithInsecure())
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer conn.Close()
+				go types.NewDriverClient(conn).Remove(context.Background(), &types.ClusterInfo{Metadata: map[string]string{"behavior": removeFinishes}})
+				<-driver.started
+
+				stopped := make(chan struct{})
+				go func() {
+					s.Shutdown(defaultDrainTimeout, defaultAbortTimeout)
+					close(stopped)
+				}()
+				waitDraining(t, s)
+				defer func() {
+					close(driver.release)
+					<-stopped
+				}()
+			} else {
+				defer s.Shutdown(0, 0)
+			}
+
+			for _, service := range []string{"", driverService} {
+				if got := probe(t, addr, service); got != test.wantServer {
+					t.Errorf("service %q is %s, want %s", service, got, test.wantServer)
+				}
+			}
+			if got := probe(t, addr, backendServicePrefix+"bare"); got != test.wantBackend {
+				t.Errorf("the backend is %s, want %s", got, test.wantBackend)
+			}
+		})
+	}
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"time"
+)
+
+// socketMode restricts the socket to the user the driver runs as, the same user as Rancher
+const socketMode = 0600
+
+// listenOptions are where the serve command listens
+type listenOptions struct {
+	Port    int
+	Address string
+	// Socket is the path of a Unix socket to listen on instead of a TCP port
+	Socket string
+}
+
+func (o listenOptions) validate() error {
+	if o.Port < 0 || o.Port > 65535 {
+		return fmt.Errorf("port %d is out of range", o.Port)
+	}
+	if o.Socket != "" && o.Port != 0 {
+		return fmt.Errorf("--socket cannot be combined with --port")
+	}
+	return nil
+}
+
+// listen opens the TCP port or the Unix socket of opts
+func listen(opts listenOptions) (net.Listener, error) {
+	if opts.Socket == "" {
+		return net.Listen("tcp", net.JoinHostPort(opts.Address, strconv.Itoa(opts.Port)))
+	}
+
+	if err := removeStaleSocket(opts.Socket); err != nil {
+		return nil, err
+	}
+	listener, err := net.Listen("unix", opts.Socket)
+	if err != nil {
+		return nil, err
+	}
+	// the socket is removed again when the listener is closed
+	if err := os.Chmod(opts.Socket, socketMode); err != nil {
+		listener.Close()
+		return nil, fmt.Errorf("error restricting the permissions of %s: %v", opts.Socket, err)
+	}
+	return listener, nil
+}
+
+// removeStaleSocket removes the socket a driver that was killed left behind. A socket another
+// driver still serves on is left alone, and so is anything that is not a socket.
+func removeStaleSocket(path string) error {
+	info, err := os.Lstat(path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if info.Mode()&os.ModeSocket == 0 {
+		return fmt.Errorf("%s exists and is not a socket", path)
+	}
+	if conn, err := net.DialTimeout("unix", path, time.Second); err == nil {
+		conn.Close()
+		return fmt.Errorf("%s is in use by another process", path)
+	}
+	return os.Remove(path)
+}
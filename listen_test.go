@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/rancher/example-kontainer-engine-driver/client"
+	"github.com/rancher/kontainer-engine/types"
+)
+
+func TestUnixSocket(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mydriver")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	socket := filepath.Join(dir, "mydriver.sock")
+
+	listener, err := listen(listenOptions{Socket: socket})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := newRPCServer(newMyDriver(), rpcServerOptions{})
+	defer s.Shutdown(0, 0)
+	go s.Serve(listener)
+
+	info, err := os.Stat(socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != socketMode {
+		t.Errorf("socket mode is %v, want %v", info.Mode().Perm(), os.FileMode(socketMode))
+	}
+
+	tests := []struct {
+		name    string
+		connect func(addr string) (types.Driver, error)
+	}{
+		{
+			name:    "types.NewClient",
+			connect: func(addr string) (types.Driver, error) { return types.NewClient("mydriver", addr) },
+		},
+		{
+			name:    "client.NewClient",
+			connect: func(addr string) (types.Driver, error) { return client.NewClient("mydriver", addr, client.Options{}) },
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			driver, err := test.connect("unix://" + socket)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := driver.GetDriverCreateOptions(context.Background()); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+func TestRemoveStaleSocket(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mydriver")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	tests := []struct {
+		name    string
+		setup   func(t *testing.T, path string) func()
+		wantErr string
+	}{
+		{name: "missing", setup: func(t *testing.T, path string) func() { return func() {} }},
+		{
+			name: "stale socket",
+			setup: func(t *testing.T, path string) func() {
+				listener, err := net.Listen("unix", path)
+				if err != nil {
+					t.Fatal(err)
+				}
+				// an unlinked listener leaves the socket behind the way a killed driver does
+				listener.(*net.UnixListener).SetUnlinkOnClose(false)
+				listener.Close()
+				return func() {}
+			},
+		},
+		{
+			name: "socket in use",
+			setup: func(t *testing.T, path string) func() {
+				listener, err := net.Listen("unix", path)
+				if err != nil {
+					t.Fatal(err)
+				}
+				return func() { listener.Close() }
+			},
+			wantErr: "is in use by another process",
+		},
+		{
+			name: "regular file",
+			setup: func(t *testing.T, path string) func() {
+				if err := ioutil.WriteFile(path, nil, 0600); err != nil {
+					t.Fatal(err)
+				}
+				return func() {}
+			},
+			wantErr: "exists and is not a socket",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			path := filepath.Join(dir, strings.Replace(test.name, " ", "-", -1))
+			defer test.setup(t, path)()
+
+			err := removeStaleSocket(path)
+			if test.wantErr != "" {
+				if err == nil || !strings.HasSuffix(err.Error(), test.wantErr) {
+					t.Fatalf("error is %v, want one ending with %q", err, test.wantErr)
+				}
+				if _, err := os.Lstat(path); err != nil {
+					t.Errorf("%s was removed", path)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := os.Lstat(path); !os.IsNotExist(err) {
+				t.Errorf("%s is still there", path)
+			}
+		})
+	}
+}
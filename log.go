@@ -0,0 +1,43 @@
+package main
+
+import (
+	"context"
+
+	"github.com/rancher/kontainer-engine/logstream"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc/metadata"
+)
+
+// clusterLog returns the logger for messages about a cluster. Rancher shows everything written
+// to the log stream named by the log-id request metadata in the provisioning log of the cluster,
+// Infof is shown as progress and Warnf as an error. Without a stream messages go to logrus
+// tagged with the cluster name.
+func clusterLog(ctx context.Context, cluster string) logstream.Logger {
+	if stream := logStream(ctx); stream != nil {
+		return stream
+	}
+	return logrus.WithField("cluster", cluster)
+}
+
+// logStream looks up the stream the same way types.GetCtx does, the rke logger it stores in the
+// context cannot be read back outside of the rke log package
+func logStream(ctx context.Context) logstream.LoggerStream {
+	logID := requestLogID(ctx)
+	if logID == "" {
+		return nil
+	}
+	return logstream.GetLogStream(logID)
+}
+
+// requestLogID returns the log-id request metadata, empty if there is none
+func requestLogID(ctx context.Context) string {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return ""
+	}
+	logID := md["log-id"]
+	if len(logID) == 0 {
+		return ""
+	}
+	return logID[0]
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/rancher/kontainer-engine/logstream"
+	"github.com/rancher/kontainer-engine/types"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc/metadata"
+)
+
+// streamEvents returns the events written to stream so far
+func streamEvents(stream logstream.LoggerStream) []logstream.LogEvent {
+	var events []logstream.LogEvent
+	for {
+		select {
+		case event := <-stream.Stream():
+			events = append(events, event)
+		default:
+			return events
+		}
+	}
+}
+
+func TestClusterLog(t *testing.T) {
+	stream := logstream.NewLogStream()
+	defer stream.Close()
+
+	tests := []struct {
+		name     string
+		ctx      context.Context
+		toStream bool
+	}{
+		{name: "log stream", ctx: metadata.NewIncomingContext(context.Background(), metadata.Pairs("log-id", stream.ID())), toStream: true},
+		{name: "unknown log stream", ctx: metadata.NewIncomingContext(context.Background(), metadata.Pairs("log-id", "unknown"))},
+		{name: "no log id", ctx: metadata.NewIncomingContext(context.Background(), metadata.Pairs("other", "x"))},
+		{name: "no metadata", ctx: context.Background()},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			log := clusterLog(test.ctx, "c1")
+			log.Infof("progress %d", 1)
+			log.Warnf("problem %d", 2)
+
+			events := streamEvents(stream)
+			if !test.toStream {
+				if len(events) != 0 {
+					t.Errorf("stream got %v, want nothing", events)
+				}
+				entry, ok := log.(*logrus.Entry)
+				if !ok || entry.Data["cluster"] != "c1" {
+					t.Errorf("logger is %#v, want logrus tagged with the cluster", log)
+				}
+				return
+			}
+			want := []logstream.LogEvent{{Message: "progress 1"}, {Error: true, Message: "problem 2"}}
+			if len(events) != len(want) || events[0] != want[0] || events[1] != want[1] {
+				t.Errorf("stream got %v, want %v", events, want)
+			}
+		})
+	}
+}
+
+func TestCreateLogsToTheStream(t *testing.T) {
+	stream := logstream.NewLogStream()
+	defer stream.Close()
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs("log-id", stream.ID()))
+
+	m := newMyDriver()
+	info, err := m.Create(ctx, &types.DriverOptions{StringOptions: map[string]string{"name": "c1"}}, &types.ClusterInfo{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Remove(context.Background(), info)
+
+	events := streamEvents(stream)
+	if len(events) == 0 || !strings.HasPrefix(events[0].Message, "creating cluster c1") {
+		t.Fatalf("stream got %v, want the create progress", events)
+	}
+	for _, event := range events {
+		if event.Error {
+			t.Errorf("create logged an error: %s", event.Message)
+		}
+	}
+}
@@ -1,33 +1,288 @@
 package main
 
 import (
-	"errors"
 	"fmt"
 	"os"
+	"runtime"
 	"strconv"
-	"sync"
 
-	"github.com/rancher/kontainer-engine/service"
 	"github.com/sirupsen/logrus"
+	"github.com/urfave/cli"
 )
 
-var wg = &sync.WaitGroup{}
+// Build metadata, set at build time with
+//
+//	go build -ldflags "-X main.Version=v0.1.0 -X main.GitCommit=$(git rev-parse HEAD) -X main.BuildDate=$(date -u +%FT%TZ)"
+var (
+	Version   = "dev"
+	GitCommit = "unknown"
+	BuildDate = "unknown"
+)
+
+// driverName is the name the driver registers under in Rancher
+const driverName = "mydriver"
+
+// Exit codes of the driver binary
+const (
+	exitOK = iota
+	// exitError means the driver failed while serving
+	exitError
+	// exitUsage means the command line or the environment is invalid
+	exitUsage
+)
 
 func main() {
-	fmt.Println("starting mydriver")
-	if os.Args[1] == "" {
-		panic(errors.New("no port provided"))
+	if err := newApp().Run(os.Args); err != nil {
+		// errors with an exit code have been handled by the app, this is anything else
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(exitError)
+	}
+	os.Exit(exitOK)
+}
+
+func newApp() *cli.App {
+	app := cli.NewApp()
+	app.Name = "mydriver"
+	app.Usage = "A kontainer-engine driver Rancher runs as a gRPC plugin"
+	app.UsageText = "mydriver [global options] command [command options] [arguments...]\n" +
+		"   mydriver PORT (serves on 127.0.0.1:PORT, the way Rancher starts drivers)"
+	app.Version = Version
+	app.OnUsageError = usageError
+	app.Action = legacyServe
+	app.Commands = []cli.Command{
+		{
+			Name:         "serve",
+			Usage:        "Serve the driver over gRPC",
+			Flags:        serveFlags(),
+			Action:       serveAction,
+			OnUsageError: usageError,
+		},
+		{
+			Name:   "version",
+			Usage:  "Print the build metadata",
+			Action: printVersion,
+		},
+	}
+	return app
+}
+
+func serveFlags() []cli.Flag {
+	return []cli.Flag{
+		cli.IntFlag{
+			Name:   "port",
+			Usage:  "The port to listen on, 0 picks a free one",
+			EnvVar: "MYDRIVER_PORT",
+		},
+		cli.StringFlag{
+			Name:   "address",
+			Usage:  "The address to listen on",
+			Value:  "127.0.0.1",
+			EnvVar: "MYDRIVER_ADDRESS",
+		},
+		cli.StringFlag{
+			Name:   "socket",
+			Usage:  "The path of a Unix socket to listen on instead of a TCP port, only the user running the driver can connect",
+			EnvVar: "MYDRIVER_SOCKET",
+		},
+		cli.StringFlag{
+			Name:   "log-level",
+			Usage:  "The log level: debug, info, warning or error",
+			Value:  "info",
+			EnvVar: "MYDRIVER_LOG_LEVEL",
+		},
+		cli.StringFlag{
+			Name:   "log-format",
+			Usage:  "The log format: text or json",
+			Value:  "text",
+			EnvVar: "MYDRIVER_LOG_FORMAT",
+		},
+		cli.StringFlag{
+			Name:   "tls-cert",
+			Usage:  "The serving certificate, serves mutual TLS along with --tls-key and --tls-client-ca",
+			EnvVar: "MYDRIVER_TLS_CERT",
+		},
+		cli.StringFlag{
+			Name:   "tls-key",
+			Usage:  "The key of the serving certificate",
+			EnvVar: "MYDRIVER_TLS_KEY",
+		},
+		cli.StringFlag{
+			Name:   "tls-client-ca",
+			Usage:  "The CA client certificates must be signed by",
+			EnvVar: "MYDRIVER_TLS_CLIENT_CA",
+		},
+		cli.BoolFlag{
+			Name:   "tls-ephemeral",
+			Usage:  "Serve mutual TLS with a CA generated at startup, the client credentials are part of the handshake",
+			EnvVar: "MYDRIVER_TLS_EPHEMERAL",
+		},
+		cli.StringFlag{
+			Name:   "token-file",
+			Usage:  "A file holding the bearer token every RPC has to present, the token can be set with " + tokenEnvVar + " instead",
+			EnvVar: "MYDRIVER_TOKEN_FILE",
+		},
+	}
+}
+
+// serveOptions are the settings of the serve command
+type serveOptions struct {
+	Listen    listenOptions
+	LogLevel  string
+	LogFormat string
+	TLS       tlsOptions
+	TokenFile string
+}
+
+// legacyServe keeps "mydriver PORT" working, it is how Rancher starts driver binaries. The
+// settings come from the MYDRIVER_ environment variables of the serve flags, a socket from
+// MYDRIVER_SOCKET needs port 0.
+func legacyServe(c *cli.Context) error {
+	if !c.Args().Present() {
+		cli.ShowAppHelp(c)
+		return cli.NewExitError("", exitUsage)
+	}
+	if len(c.Args()) > 1 {
+		return cli.NewExitError(fmt.Sprintf("unexpected arguments after the port: %v", c.Args().Tail()), exitUsage)
+	}
+	port, err := strconv.Atoi(c.Args().First())
+	if err != nil {
+		return cli.NewExitError(fmt.Sprintf("%q is neither a command nor a port", c.Args().First()), exitUsage)
+	}
+	// the environment configures the driver the same way it does for the serve command
+	ephemeral, err := strconv.ParseBool(envOrDefault("MYDRIVER_TLS_EPHEMERAL", "false"))
+	if err != nil {
+		return cli.NewExitError(fmt.Sprintf("MYDRIVER_TLS_EPHEMERAL: %q is not a boolean", os.Getenv("MYDRIVER_TLS_EPHEMERAL")), exitUsage)
+	}
+	return serve(serveOptions{
+		Listen: listenOptions{
+			Port:    port,
+			Address: envOrDefault("MYDRIVER_ADDRESS", "127.0.0.1"),
+			Socket:  os.Getenv("MYDRIVER_SOCKET"),
+		},
+		LogLevel:  envOrDefault("MYDRIVER_LOG_LEVEL", "info"),
+		LogFormat: envOrDefault("MYDRIVER_LOG_FORMAT", "text"),
+		TLS: tlsOptions{
+			CertFile:     os.Getenv("MYDRIVER_TLS_CERT"),
+			KeyFile:      os.Getenv("MYDRIVER_TLS_KEY"),
+			ClientCAFile: os.Getenv("MYDRIVER_TLS_CLIENT_CA"),
+			Ephemeral:    ephemeral,
+		},
+		TokenFile: os.Getenv("MYDRIVER_TOKEN_FILE"),
+	})
+}
+
+func serveAction(c *cli.Context) error {
+	if c.Args().Present() {
+		return cli.NewExitError(fmt.Sprintf("unexpected arguments: %v", []string(c.Args())), exitUsage)
+	}
+	return serve(serveOptions{
+		Listen: listenOptions{
+			Port:    c.Int("port"),
+			Address: c.String("address"),
+			Socket:  c.String("socket"),
+		},
+		LogLevel:  c.String("log-level"),
+		LogFormat: c.String("log-format"),
+		TLS: tlsOptions{
+			CertFile:     c.String("tls-cert"),
+			KeyFile:      c.String("tls-key"),
+			ClientCAFile: c.String("tls-client-ca"),
+			Ephemeral:    c.Bool("tls-ephemeral"),
+		},
+		TokenFile: c.String("token-file"),
+	})
+}
+
+func serve(opts serveOptions) error {
+	if err := configureLogging(opts.LogLevel, opts.LogFormat); err != nil {
+		return cli.NewExitError(err.Error(), exitUsage)
+	}
+	if err := opts.Listen.validate(); err != nil {
+		return cli.NewExitError(err.Error(), exitUsage)
+	}
+	if err := opts.TLS.validate(); err != nil {
+		return cli.NewExitError(err.Error(), exitUsage)
+	}
+	tlsConfig, credentials, err := serverTLS(opts.TLS, opts.Listen.Address)
+	if err != nil {
+		return cli.NewExitError(err.Error(), exitUsage)
+	}
+	token, err := loadToken(opts.TokenFile)
+	if err != nil {
+		return cli.NewExitError(err.Error(), exitUsage)
 	}
 
-	port, err := strconv.Atoi(os.Args[1])
+	logrus.Info("starting mydriver")
+	listener, err := listen(opts.Listen)
 	if err != nil {
-		panic(fmt.Errorf("argument not parsable as int: %v", err))
+		return cli.NewExitError(fmt.Sprintf("error listening: %v", err), exitError)
+	}
+	driver := newMyDriver()
+	server := newRPCServer(driver, rpcServerOptions{
+		TLS:          tlsConfig,
+		Token:        token,
+		HealthChecks: driver.healthChecks(),
+	})
+	served := make(chan error, 1)
+	go func() {
+		served <- server.Serve(listener)
+	}()
+	if err := writeHandshake(os.Stdout, listener, driverName, tlsConfig != nil, credentials); err != nil {
+		server.Shutdown(0, 0)
+		return cli.NewExitError(fmt.Sprintf("error writing the handshake: %v", err), exitError)
 	}
 
-	service.RegisterDriverForPort("mydriver", &MyDriver{}, port)
+	logrus.Infof("mydriver %s listening on %s", Version, listener.Addr())
+	go func() {
+		server.MarkReady()
+		logrus.Info("mydriver is ready")
+	}()
 
-	logrus.Infof("mydriver up and running on port %v", port)
+	// run until told to stop or orphaned, then let the RPCs in flight record their progress
+	select {
+	case reason := <-shutdownSignals():
+		logrus.Infof("shutting down mydriver: %s", reason)
+		server.Shutdown(defaultDrainTimeout, defaultAbortTimeout)
+		logrus.Info("mydriver stopped")
+		return nil
+	case err := <-served:
+		return cli.NewExitError(fmt.Sprintf("error serving: %v", err), exitError)
+	}
+}
 
-	wg.Add(1)
-	wg.Wait() // wait forever, we only exit if killed by parent process
+func configureLogging(level, format string) error {
+	lvl, err := logrus.ParseLevel(level)
+	if err != nil {
+		return fmt.Errorf("invalid log level %q", level)
+	}
+	logrus.SetLevel(lvl)
+
+	switch format {
+	case "text":
+		logrus.SetFormatter(&logrus.TextFormatter{})
+	case "json":
+		logrus.SetFormatter(&logrus.JSONFormatter{})
+	default:
+		return fmt.Errorf("invalid log format %q, use text or json", format)
+	}
+	return nil
+}
+
+func printVersion(c *cli.Context) error {
+	fmt.Fprintf(c.App.Writer, "mydriver %s\n", Version)
+	fmt.Fprintf(c.App.Writer, "git commit: %s\n", GitCommit)
+	fmt.Fprintf(c.App.Writer, "build date: %s\n", BuildDate)
+	fmt.Fprintf(c.App.Writer, "go version: %s %s/%s\n", runtime.Version(), runtime.GOOS, runtime.GOARCH)
+	return nil
+}
+
+func usageError(c *cli.Context, err error, isSubcommand bool) error {
+	return cli.NewExitError(fmt.Sprintf("incorrect usage: %v", err), exitUsage)
+}
+
+func envOrDefault(name, value string) string {
+	if v := os.Getenv(name); v != "" {
+		return v
+	}
+	return value
 }
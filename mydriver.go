@@ -2,54 +2,363 @@ package main
 
 import (
 	"context"
+	"fmt"
+	"reflect"
+	"time"
 
+	"github.com/rancher/example-kontainer-engine-driver/wait"
 	"github.com/rancher/kontainer-engine/types"
 	"github.com/sirupsen/logrus"
 )
 
 type MyDriver struct {
-	types.UnimplementedClusterSizeAccess
-	types.UnimplementedVersionAccess
+	backend clusterBackend
+	// clientset connects to the clusters for the post check
+	clientset clientsetFactory
+	// backoff controls waiting for the clusters to become reachable
+	backoff wait.Backoff
 }
 
-func (m *MyDriver) GetDriverCreateOptions(ctx context.Context) (*types.DriverFlags, error) {
-	driverFlag := types.DriverFlags{
-		Options: make(map[string]*types.Flag),
-	}
-	driverFlag.Options["name"] = &types.Flag{
-		Type:  types.StringType,
-		Usage: "The internal name of the cluster in Rancher",
+func newMyDriver() *MyDriver {
+	return &MyDriver{
+		backend:   newSimulatedBackend(),
+		clientset: newClientset,
+		backoff:   wait.DefaultBackoff,
 	}
-	return &driverFlag, nil
+}
+
+func (m *MyDriver) GetDriverCreateOptions(ctx context.Context) (*types.DriverFlags, error) {
+	return getDriverCreateOptions(), nil
 }
 
 func (m *MyDriver) GetDriverUpdateOptions(ctx context.Context) (*types.DriverFlags, error) {
-	return nil, nil
+	return getDriverUpdateOptions(), nil
 }
 
 func (m *MyDriver) Create(ctx context.Context, opts *types.DriverOptions, clusterInfo *types.ClusterInfo) (*types.ClusterInfo, error) {
 	logrus.Infof("mydriver create called")
-	logrus.Infof("options provided: %v", opts)
-	logrus.Infof("cluster info: %v", clusterInfo)
-	return &types.ClusterInfo{}, nil
+	logrus.Infof("options provided: %v", redactOptions(opts))
+	logrus.Infof("cluster info: %v", redactClusterInfo(clusterInfo))
+
+	// on failure the cluster info is returned along with the error whenever there is one, Rancher
+	// persists it as the create error and hands it back to a retry or Remove
+	fresh, err := getStateFromOpts(opts)
+	if err != nil {
+		return clusterInfo, err
+	}
+	state, info, err := m.resumeState(fresh, clusterInfo)
+	if err != nil {
+		return clusterInfo, err
+	}
+
+	ctx, cancel := withTimeout(ctx, state.Timeouts.Create)
+	defer cancel()
+
+	log := clusterLog(ctx, state.Name)
+	log.Infof("creating cluster %s with %d nodes running kubernetes %s", state.Name, totalNodeCount(state.NodePools), state.KubernetesVersion)
+	if err := m.provision(ctx, &state, info); err != nil {
+		log.Warnf("error creating cluster %s after completing %s: %v", state.Name, checkpointSummary(state), err)
+		return info, err
+	}
+	log.Infof("cluster %s is running at %s", state.Name, state.Endpoint)
+	return info, nil
 }
 
 func (m *MyDriver) Update(ctx context.Context, clusterInfo *types.ClusterInfo, opts *types.DriverOptions) (*types.ClusterInfo, error) {
 	logrus.Infof("mydriver updated called")
-	return clusterInfo, nil
+	logrus.Infof("options provided: %v", redactOptions(opts))
+	state, err := getState(clusterInfo)
+	if err != nil {
+		return nil, err
+	}
+	if err := m.refreshVersion(ctx, &state); err != nil {
+		return nil, err
+	}
+	if err := m.refreshNodePools(ctx, &state); err != nil {
+		return nil, err
+	}
+
+	config, err := validateUpdateConfig(opts, state)
+	if err != nil {
+		return nil, err
+	}
+
+	if config.DisplayName != "" {
+		state.DisplayName = config.DisplayName
+	}
+	if config.ResizePolicy != "" {
+		state.ResizePolicy = config.ResizePolicy
+	}
+	if config.ResizePool != "" {
+		state.ResizePool = config.ResizePool
+	}
+	// a timeout of 0 waits forever, so the timeouts are only left alone when the update omits them
+	if optionSet(opts, "update-timeout") {
+		state.Timeouts.Update = config.UpdateTimeout
+	}
+	if optionSet(opts, "remove-timeout") {
+		state.Timeouts.Remove = config.RemoveTimeout
+	}
+	if _, err := resizeTarget(state.NodePools, state.ResizePolicy, state.ResizePool); err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := withTimeout(ctx, state.Timeouts.Update)
+	defer cancel()
+
+	if config.KubernetesVersion != "" {
+		if err := m.upgrade(ctx, &state, config.KubernetesVersion); err != nil {
+			return nil, err
+		}
+	}
+
+	pools, err := updatedNodePools(config, state.NodePools)
+	if err != nil {
+		return nil, err
+	}
+	if !reflect.DeepEqual(pools, state.NodePools) {
+		if err := m.resize(ctx, &state, pools); err != nil {
+			// gRPC drops the cluster info of a failed RPC, an upgrade done before the resize
+			// reaches the state when the next Update reads the version back from the backend
+			return nil, err
+		}
+	}
+
+	if identity := updatedBootstrapIdentity(config, state.Bootstrap); !identity.sameGrant(state.Bootstrap) || state.Bootstrap.legacyBinding() {
+		if err := m.replaceBootstrapIdentity(ctx, &state, clusterInfo, identity); err != nil {
+			return nil, operationError(ctx, "changing the service account of cluster "+state.Name, err)
+		}
+	}
+
+	clusterInfo.Version = state.KubernetesVersion
+	clusterInfo.NodeCount = totalNodeCount(state.NodePools)
+	return clusterInfo, storeState(clusterInfo, state)
 }
 
 func (m *MyDriver) PostCheck(ctx context.Context, clusterInfo *types.ClusterInfo) (*types.ClusterInfo, error) {
 	logrus.Infof("mydriver post check called")
-	return clusterInfo, nil
+	state, err := getState(clusterInfo)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := withTimeout(ctx, state.Timeouts.Create)
+	defer cancel()
+
+	log := clusterLog(ctx, state.Name)
+	log.Infof("checking cluster %s", state.Name)
+	if err := m.postCheck(ctx, &state, clusterInfo); err != nil {
+		err = operationError(ctx, "checking cluster "+state.Name, err)
+		log.Warnf("error checking cluster %s: %v", state.Name, err)
+		return nil, err
+	}
+	log.Infof("cluster %s runs kubernetes %s on %d nodes", state.Name, clusterInfo.Version, clusterInfo.NodeCount)
+	return clusterInfo, storeState(clusterInfo, state)
 }
 
 func (m *MyDriver) Remove(ctx context.Context, clusterInfo *types.ClusterInfo) error {
 	logrus.Infof("mydriver remove called")
+	state, err := getState(clusterInfo)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := withTimeout(ctx, state.Timeouts.Remove)
+	defer cancel()
+
+	clusterLog(ctx, state.Name).Infof("removing cluster %s", state.Name)
+	if err := m.backend.Remove(ctx, &state); err != nil {
+		return operationError(ctx, "removing cluster "+state.Name, err)
+	}
 	return nil
 }
 
 func (m *MyDriver) GetCapabilities(ctx context.Context) (*types.Capabilities, error) {
 	logrus.Infof("mydriver getcaps called")
-	return nil, nil
+	return capabilitiesOf(m.backend), nil
+}
+
+func (m *MyDriver) GetVersion(ctx context.Context, info *types.ClusterInfo) (*types.KubernetesVersion, error) {
+	reader, ok := m.backend.(versionReader)
+	if !ok {
+		return nil, fmt.Errorf("the %s backend cannot report the kubernetes version", m.backend.Name())
+	}
+	state, err := getState(info)
+	if err != nil {
+		return nil, err
+	}
+
+	version, err := reader.GetVersion(ctx, &state)
+	if err != nil {
+		return nil, operationError(ctx, "getting the version of cluster "+state.Name, err)
+	}
+	return &types.KubernetesVersion{Version: version}, nil
+}
+
+// SetVersion upgrades the cluster. Rancher does not store the cluster info after a SetVersion,
+// so the upgrade reaches the state when the next Update reads the version back from the backend.
+func (m *MyDriver) SetVersion(ctx context.Context, info *types.ClusterInfo, version *types.KubernetesVersion) error {
+	if version == nil || version.Version == "" {
+		return fmt.Errorf("kubernetes version is required")
+	}
+	state, err := getState(info)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := withTimeout(ctx, state.Timeouts.Update)
+	defer cancel()
+
+	if err := m.refreshVersion(ctx, &state); err != nil {
+		return err
+	}
+	return m.upgrade(ctx, &state, version.Version)
+}
+
+// upgrade moves the cluster to version following the upgrade path rules and records the upgrade in state
+func (m *MyDriver) upgrade(ctx context.Context, state *state, version string) error {
+	writer, ok := m.backend.(versionWriter)
+	if !ok {
+		return fmt.Errorf("the %s backend cannot change the kubernetes version", m.backend.Name())
+	}
+
+	if err := checkUpgrade(state.KubernetesVersion, version); err != nil {
+		return err
+	}
+	target, _ := lookupVersion(version)
+	if target.String() == state.KubernetesVersion {
+		clusterLog(ctx, state.Name).Infof("cluster %s already runs kubernetes %s", state.Name, target)
+		return nil
+	}
+
+	operation := fmt.Sprintf("upgrading cluster %s to kubernetes %s", state.Name, target)
+	if err := contextError(ctx, operation); err != nil {
+		return err
+	}
+
+	log := clusterLog(ctx, state.Name)
+	log.Infof("upgrading cluster %s from kubernetes %s to %s", state.Name, state.KubernetesVersion, target)
+	if err := writer.SetVersion(ctx, state, target.String()); err != nil {
+		err = operationError(ctx, operation, err)
+		log.Warnf("error upgrading cluster %s to kubernetes %s: %v", state.Name, target, err)
+		return err
+	}
+
+	state.Upgrades = append(state.Upgrades, versionUpgrade{
+		From: state.KubernetesVersion,
+		To:   target.String(),
+		Time: time.Now().UTC(),
+	})
+	state.KubernetesVersion = target.String()
+	return nil
+}
+
+// refreshVersion replaces the kubernetes version in the state with the one the backend reports,
+// a version changed by SetVersion since the state was stored is recorded as an upgrade
+func (m *MyDriver) refreshVersion(ctx context.Context, state *state) error {
+	reader, ok := m.backend.(versionReader)
+	if !ok {
+		return nil
+	}
+	version, err := reader.GetVersion(ctx, state)
+	if err != nil {
+		return err
+	}
+	if version != state.KubernetesVersion {
+		state.Upgrades = append(state.Upgrades, versionUpgrade{
+			From: state.KubernetesVersion,
+			To:   version,
+			Time: time.Now().UTC(),
+		})
+		state.KubernetesVersion = version
+	}
+	return nil
+}
+
+func (m *MyDriver) GetClusterSize(ctx context.Context, info *types.ClusterInfo) (*types.NodeCount, error) {
+	reader, ok := m.backend.(sizeReader)
+	if !ok {
+		return nil, fmt.Errorf("the %s backend cannot report the cluster size", m.backend.Name())
+	}
+	state, err := getState(info)
+	if err != nil {
+		return nil, err
+	}
+
+	count, err := reader.GetClusterSize(ctx, &state)
+	if err != nil {
+		return nil, operationError(ctx, "getting the size of cluster "+state.Name, err)
+	}
+	return &types.NodeCount{Count: count}, nil
+}
+
+// SetClusterSize resizes the cluster. Rancher does not store the cluster info after a
+// SetClusterSize, so the sizes are read back from the backend before every resize and the next
+// Update records them in the state.
+func (m *MyDriver) SetClusterSize(ctx context.Context, info *types.ClusterInfo, count *types.NodeCount) error {
+	if count == nil {
+		return fmt.Errorf("node count is required")
+	}
+	state, err := getState(info)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := withTimeout(ctx, state.Timeouts.Update)
+	defer cancel()
+
+	if err := m.refreshNodePools(ctx, &state); err != nil {
+		return err
+	}
+
+	pools, err := resizePools(state.NodePools, state.ResizePolicy, state.ResizePool, count.Count)
+	if err != nil {
+		return err
+	}
+
+	return m.resize(ctx, &state, pools)
+}
+
+// refreshNodePools replaces the node counts in the state with the ones the backend reports, a
+// SetClusterSize since the state was stored leaves them stale
+func (m *MyDriver) refreshNodePools(ctx context.Context, state *state) error {
+	writer, ok := m.backend.(sizeWriter)
+	if !ok {
+		return nil
+	}
+	sizes, err := writer.GetNodePoolSizes(ctx, state)
+	if err != nil {
+		return err
+	}
+	pools := make([]nodePool, len(state.NodePools))
+	for i, pool := range state.NodePools {
+		pool.Count = sizes[pool.Name]
+		pools[i] = pool
+	}
+	state.NodePools = pools
+	return nil
+}
+
+// resize applies new node pool sizes to the cluster and records them in state
+func (m *MyDriver) resize(ctx context.Context, state *state, pools []nodePool) error {
+	writer, ok := m.backend.(sizeWriter)
+	if !ok {
+		return fmt.Errorf("the %s backend cannot change the cluster size", m.backend.Name())
+	}
+
+	operation := fmt.Sprintf("resizing cluster %s to %d nodes", state.Name, totalNodeCount(pools))
+	if err := contextError(ctx, operation); err != nil {
+		return err
+	}
+
+	log := clusterLog(ctx, state.Name)
+	log.Infof("resizing cluster %s from %d to %d nodes", state.Name, totalNodeCount(state.NodePools), totalNodeCount(pools))
+	previous := state.NodePools
+	state.NodePools = pools
+	if err := writer.SetNodePools(ctx, state); err != nil {
+		err = operationError(ctx, operation, err)
+		log.Warnf("error resizing cluster %s: %v", state.Name, err)
+		state.NodePools = previous
+		return err
+	}
+	return nil
 }
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/rancher/kontainer-engine/types"
+)
+
+// createCluster creates a cluster on the simulated backend, the caller removes it
+func createCluster(t *testing.T, m *MyDriver, opts *types.DriverOptions) *types.ClusterInfo {
+	t.Helper()
+	info, err := m.Create(context.Background(), opts, &types.ClusterInfo{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return info
+}
+
+func TestSetVersion(t *testing.T) {
+	ctx := context.Background()
+	m := newMyDriver()
+	info := createCluster(t, m, &types.DriverOptions{
+		StringOptions: map[string]string{"name": "c1", "kubernetes-version": "v1.9.7"},
+	})
+	defer m.Remove(ctx, info)
+
+	// Rancher does not store the cluster info after SetVersion, every call gets the one from create
+	for _, version := range []string{"v1.10.5", "v1.11.1"} {
+		if err := m.SetVersion(ctx, info, &types.KubernetesVersion{Version: version}); err != nil {
+			t.Fatalf("upgrading to %s: %v", version, err)
+		}
+	}
+	if err := m.SetVersion(ctx, info, &types.KubernetesVersion{Version: "v1.10.5"}); err == nil {
+		t.Error("downgrading from v1.11.1 to v1.10.5 passed")
+	}
+
+	version, err := m.GetVersion(ctx, info)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version.Version != "v1.11.1" {
+		t.Errorf("version is %s, want v1.11.1", version.Version)
+	}
+
+	info, err = m.Update(ctx, info, &types.DriverOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	state, err := getState(info)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state.KubernetesVersion != "v1.11.1" || info.Version != "v1.11.1" {
+		t.Errorf("update recorded version %s and reported %s, want v1.11.1", state.KubernetesVersion, info.Version)
+	}
+	if len(state.Upgrades) != 1 || state.Upgrades[0].From != "v1.9.7" || state.Upgrades[0].To != "v1.11.1" {
+		t.Errorf("upgrades are %+v, want one from v1.9.7 to v1.11.1", state.Upgrades)
+	}
+}
+
+func TestSetClusterSize(t *testing.T) {
+	ctx := context.Background()
+	m := newMyDriver()
+	info := createCluster(t, m, &types.DriverOptions{
+		StringOptions:      map[string]string{"name": "c1", "resize-policy": "smallest"},
+		StringSliceOptions: map[string]*types.StringSlice{"node-pools": {Value: []string{"name=a,count=2", "name=b,count=3"}}},
+	})
+	defer m.Remove(ctx, info)
+
+	// the second resize has to start from the sizes of the first, b is the smallest pool by then
+	for _, count := range []int64{7, 8} {
+		if err := m.SetClusterSize(ctx, info, &types.NodeCount{Count: count}); err != nil {
+			t.Fatalf("resizing to %d nodes: %v", count, err)
+		}
+	}
+
+	size, err := m.GetClusterSize(ctx, info)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size.Count != 8 {
+		t.Errorf("cluster size is %d, want 8", size.Count)
+	}
+
+	info, err = m.Update(ctx, info, &types.DriverOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	state, err := getState(info)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sizes := map[string]int64{}
+	for _, pool := range state.NodePools {
+		sizes[pool.Name] = pool.Count
+	}
+	want := map[string]int64{"a": 4, "b": 4}
+	if !reflect.DeepEqual(sizes, want) || info.NodeCount != 8 {
+		t.Errorf("update recorded node pool sizes %v and reported %d nodes, want %v and 8 nodes", sizes, info.NodeCount, want)
+	}
+}
+
+func TestUpdateTimeouts(t *testing.T) {
+	tests := []struct {
+		name string
+		opts map[string]string
+		want operationTimeouts
+	}{
+		{
+			name: "omitted timeouts are kept",
+			want: operationTimeouts{Create: time.Hour, Update: time.Hour, Remove: time.Hour},
+		},
+		{
+			name: "new timeouts",
+			opts: map[string]string{"update-timeout": "5m", "remove-timeout": "1m"},
+			want: operationTimeouts{Create: time.Hour, Update: 5 * time.Minute, Remove: time.Minute},
+		},
+		{
+			name: "0 waits forever",
+			opts: map[string]string{"update-timeout": "0", "remove-timeout": "0s"},
+			want: operationTimeouts{Create: time.Hour},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			m := &MyDriver{backend: newFakeBackend()}
+			info := &types.ClusterInfo{}
+			err := storeState(info, state{
+				Name:      "c1",
+				Backend:   "fake",
+				NodePools: []nodePool{{Name: defaultNodePoolName, Count: 1}},
+				Timeouts:  operationTimeouts{Create: time.Hour, Update: time.Hour, Remove: time.Hour},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			info, err = m.Update(context.Background(), info, &types.DriverOptions{StringOptions: test.opts})
+			if err != nil {
+				t.Fatal(err)
+			}
+			state, err := getState(info)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if state.Timeouts != test.want {
+				t.Errorf("timeouts are %+v, want %+v", state.Timeouts, test.want)
+			}
+		})
+	}
+}
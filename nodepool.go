@@ -0,0 +1,179 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	defaultNodePoolName = "default"
+	nodePoolLabel       = "mydriver.rancher.io/node-pool"
+
+	resizeFirst    = "first"
+	resizeLast     = "last"
+	resizeLargest  = "largest"
+	resizeSmallest = "smallest"
+)
+
+// resizePolicies are the rules for picking the node pool that absorbs a cluster size change
+var resizePolicies = []string{resizeLast, resizeFirst, resizeLargest, resizeSmallest}
+
+// nodePool is a group of identically configured nodes
+type nodePool struct {
+	// The name of the pool, unique within the cluster
+	Name string
+	// The number of nodes in the pool
+	Count int64
+	// The minimum number of nodes the pool can be scaled down to
+	MinCount int64
+	// The maximum number of nodes the pool can be scaled up to, 0 means unbounded
+	MaxCount int64
+	// Labels applied to every node of the pool
+	Labels map[string]string
+	// Taints applied to every node of the pool in the key=value:Effect form
+	Taints []string
+}
+
+// parseNodePool parses the node-pools option format, a comma separated list of key=value
+// pairs such as "name=workers,count=3,min=1,max=5,label=role=worker,taint=dedicated=gpu:NoSchedule"
+func parseNodePool(spec string) (nodePool, error) {
+	pool := nodePool{
+		Labels: map[string]string{},
+	}
+	for _, field := range strings.Split(spec, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		kv := strings.SplitN(field, "=", 2)
+		if len(kv) != 2 {
+			return pool, fmt.Errorf("node pool %q: %q is not of the form key=value", spec, field)
+		}
+		key, value := kv[0], kv[1]
+
+		var err error
+		switch key {
+		case "name":
+			pool.Name = value
+		case "count":
+			pool.Count, err = strconv.ParseInt(value, 10, 64)
+		case "min":
+			pool.MinCount, err = strconv.ParseInt(value, 10, 64)
+		case "max":
+			pool.MaxCount, err = strconv.ParseInt(value, 10, 64)
+		case "label":
+			label := strings.SplitN(value, "=", 2)
+			if len(label) != 2 {
+				return pool, fmt.Errorf("node pool %q: label %q is not of the form key=value", spec, value)
+			}
+			pool.Labels[label[0]] = label[1]
+		case "taint":
+			if _, _, _, err := parseTaint(value); err != nil {
+				return pool, fmt.Errorf("node pool %q: %v", spec, err)
+			}
+			pool.Taints = append(pool.Taints, value)
+		default:
+			return pool, fmt.Errorf("node pool %q: unknown key %q", spec, key)
+		}
+		if err != nil {
+			return pool, fmt.Errorf("node pool %q: %s must be a number", spec, key)
+		}
+	}
+
+	if pool.Name == "" {
+		return pool, fmt.Errorf("node pool %q: name is required", spec)
+	}
+	return pool, nil
+}
+
+// parseTaint splits a taint of the form key=value:Effect, the value is optional
+func parseTaint(taint string) (string, string, string, error) {
+	i := strings.LastIndex(taint, ":")
+	if i < 0 {
+		return "", "", "", fmt.Errorf("taint %q is not of the form key=value:Effect", taint)
+	}
+	keyValue, effect := taint[:i], taint[i+1:]
+	switch effect {
+	case "NoSchedule", "PreferNoSchedule", "NoExecute":
+	default:
+		return "", "", "", fmt.Errorf("taint %q has unknown effect %q", taint, effect)
+	}
+	kv := strings.SplitN(keyValue, "=", 2)
+	if kv[0] == "" {
+		return "", "", "", fmt.Errorf("taint %q has no key", taint)
+	}
+	if len(kv) == 1 {
+		return kv[0], "", effect, nil
+	}
+	return kv[0], kv[1], effect, nil
+}
+
+func totalNodeCount(pools []nodePool) int64 {
+	var total int64
+	for _, pool := range pools {
+		total += pool.Count
+	}
+	return total
+}
+
+// resizeTarget returns the index of the pool that absorbs a size change, a named pool takes
+// precedence over the policy
+func resizeTarget(pools []nodePool, policy, poolName string) (int, error) {
+	if len(pools) == 0 {
+		return -1, fmt.Errorf("cluster has no node pools")
+	}
+	if poolName != "" {
+		for i, pool := range pools {
+			if pool.Name == poolName {
+				return i, nil
+			}
+		}
+		return -1, fmt.Errorf("node pool %s does not exist", poolName)
+	}
+
+	indexes := make([]int, len(pools))
+	for i := range indexes {
+		indexes[i] = i
+	}
+	switch policy {
+	case resizeFirst:
+		return 0, nil
+	case resizeLast, "":
+		return len(pools) - 1, nil
+	case resizeLargest:
+		sort.SliceStable(indexes, func(i, j int) bool { return pools[indexes[i]].Count > pools[indexes[j]].Count })
+		return indexes[0], nil
+	case resizeSmallest:
+		sort.SliceStable(indexes, func(i, j int) bool { return pools[indexes[i]].Count < pools[indexes[j]].Count })
+		return indexes[0], nil
+	}
+	return -1, fmt.Errorf("unknown resize policy %q, must be one of %s", policy, strings.Join(resizePolicies, ", "))
+}
+
+// resizePools returns a copy of pools where a single pool absorbs the difference to count nodes
+func resizePools(pools []nodePool, policy, poolName string, count int64) ([]nodePool, error) {
+	if count < 0 {
+		return nil, fmt.Errorf("node count cannot be negative")
+	}
+	i, err := resizeTarget(pools, policy, poolName)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]nodePool, len(pools))
+	copy(result, pools)
+	pool := &result[i]
+	size := pool.Count + count - totalNodeCount(pools)
+	switch {
+	case size < 0:
+		return nil, fmt.Errorf("cannot resize the cluster to %d nodes, node pool %s only has %d nodes", count, pool.Name, pool.Count)
+	case size < pool.MinCount:
+		return nil, fmt.Errorf("cannot resize the cluster to %d nodes, node pool %s would have %d nodes which is below its minimum of %d", count, pool.Name, size, pool.MinCount)
+	case pool.MaxCount > 0 && size > pool.MaxCount:
+		return nil, fmt.Errorf("cannot resize the cluster to %d nodes, node pool %s would have %d nodes which is above its maximum of %d", count, pool.Name, size, pool.MaxCount)
+	}
+	pool.Count = size
+	return result, nil
+}
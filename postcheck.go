@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+
+	"github.com/rancher/kontainer-engine/types"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/version"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+// clientsetFactory builds a client for the cluster described by info, tests substitute one that
+// returns a clientset from k8s.io/client-go/kubernetes/fake
+type clientsetFactory func(info *types.ClusterInfo) (kubernetes.Interface, error)
+
+// newClientset connects with the client certificate of the cluster info, or with its service
+// account token when there is no certificate
+func newClientset(info *types.ClusterInfo) (kubernetes.Interface, error) {
+	capem, err := base64.StdEncoding.DecodeString(info.RootCaCertificate)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding root ca certificate: %v", err)
+	}
+	cert, err := base64.StdEncoding.DecodeString(info.ClientCertificate)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding client certificate: %v", err)
+	}
+	key, err := base64.StdEncoding.DecodeString(info.ClientKey)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding client key: %v", err)
+	}
+
+	config := &rest.Config{
+		Host: info.Endpoint,
+		TLSClientConfig: rest.TLSClientConfig{
+			CAData:   capem,
+			CertData: cert,
+			KeyData:  key,
+		},
+	}
+	if len(cert) == 0 {
+		config.TLSClientConfig.CertData = nil
+		config.TLSClientConfig.KeyData = nil
+		config.BearerToken = info.ServiceAccountToken
+	}
+
+	clientset, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return nil, fmt.Errorf("error creating clientset: %v", err)
+	}
+	return clientset, nil
+}
+
+// postCheck waits until the api server of the cluster answers, then reports the version and
+// size of the cluster and mints the service account token Rancher manages it with. Once that
+// token exists, the grants it replaces are revoked: a binding left from before the bootstrap
+// identity was configurable and the admin token of the backend.
+func (m *MyDriver) postCheck(ctx context.Context, state *state, info *types.ClusterInfo) error {
+	clientset, err := m.clientset(info)
+	if err != nil {
+		return err
+	}
+
+	var serverVersion *version.Info
+	err = waitFor(ctx, m.backoff, state.Name, fmt.Sprintf("the api server of cluster %s", state.Name), func(ctx context.Context) (bool, error) {
+		v, err := clientset.Discovery().ServerVersion()
+		serverVersion = v
+		return err == nil, err
+	})
+	if err != nil {
+		return fmt.Errorf("error reaching the api server of cluster %s: %v", state.Name, err)
+	}
+
+	nodes, err := clientset.CoreV1().Nodes().List(metav1.ListOptions{})
+	if err != nil {
+		return fmt.Errorf("error listing the nodes of cluster %s: %v", state.Name, err)
+	}
+
+	previous := state.Bootstrap
+	token, identity, err := m.serviceAccountToken(ctx, *state, clientset)
+	if err != nil {
+		return err
+	}
+
+	state.Bootstrap = identity
+	info.Version = serverVersion.GitVersion
+	info.NodeCount = int64(len(nodes.Items))
+	info.ServiceAccountToken = token
+
+	if err := revokeBootstrapIdentity(clientset, previous, identity); err != nil {
+		return err
+	}
+	if revoker, ok := m.backend.(adminTokenRevoker); ok {
+		if err := revoker.RevokeAdminToken(ctx, state); err != nil {
+			return fmt.Errorf("error revoking the admin token of cluster %s: %v", state.Name, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,296 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rancher/example-kontainer-engine-driver/wait"
+	"github.com/rancher/kontainer-engine/types"
+	"k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/version"
+	fakediscovery "k8s.io/client-go/discovery/fake"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+// tokenSecretSuffix names the token secrets of the fake clientset
+const tokenSecretSuffix = "-token"
+
+// newFakeClientset returns a clientset serving gitVersion with the given nodes and the
+// cluster-admin role. With tokens it acts like the token controller and gives every service
+// account created in it a token secret, the secrets are served by a reactor since the vendored
+// fake has no tracker to add them to.
+func newFakeClientset(gitVersion string, nodes int, tokens bool) *fake.Clientset {
+	objects := []runtime.Object{
+		&rbacv1.ClusterRole{ObjectMeta: metav1.ObjectMeta{Name: "cluster-admin"}},
+	}
+	for i := 0; i < nodes; i++ {
+		objects = append(objects, &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: fmt.Sprintf("node-%d", i)}})
+	}
+	clientset := fake.NewSimpleClientset(objects...)
+	clientset.Discovery().(*fakediscovery.FakeDiscovery).FakedServerVersion = &version.Info{GitVersion: gitVersion}
+	if !tokens {
+		return clientset
+	}
+
+	clientset.PrependReactor("create", "serviceaccounts", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		serviceAccount := action.(k8stesting.CreateAction).GetObject().(*v1.ServiceAccount)
+		serviceAccount.Secrets = append(serviceAccount.Secrets, v1.ObjectReference{Name: serviceAccount.Name + tokenSecretSuffix})
+		// the tracker stores the service account
+		return false, nil, nil
+	})
+	clientset.PrependReactor("get", "secrets", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		name := action.(k8stesting.GetAction).GetName()
+		if !strings.HasSuffix(name, tokenSecretSuffix) {
+			return false, nil, nil
+		}
+		return true, &v1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      name,
+				Namespace: action.GetNamespace(),
+			},
+			Type: v1.SecretTypeServiceAccountToken,
+			Data: map[string][]byte{v1.ServiceAccountTokenKey: []byte("token-of-" + strings.TrimSuffix(name, tokenSecretSuffix))},
+		}, nil
+	})
+	return clientset
+}
+
+// adminTokenBackend records the clusters whose admin token was revoked
+type adminTokenBackend struct {
+	bareBackend
+	revoked []string
+}
+
+func (b *adminTokenBackend) RevokeAdminToken(ctx context.Context, state *state) error {
+	b.revoked = append(b.revoked, state.Name)
+	return nil
+}
+
+// newPostCheckDriver returns a driver that reaches every cluster through clientset and gives up
+// waiting after a few quick attempts
+func newPostCheckDriver(backend clusterBackend, clientset kubernetes.Interface) *MyDriver {
+	backoff := wait.DefaultBackoff
+	backoff.Initial = time.Millisecond
+	backoff.MaxAttempts = 3
+	return &MyDriver{
+		backend: backend,
+		clientset: func(*types.ClusterInfo) (kubernetes.Interface, error) {
+			return clientset, nil
+		},
+		backoff: backoff,
+	}
+}
+
+// newPostCheckInfo returns the cluster info Create leaves for a cluster created with options
+func newPostCheckInfo(t *testing.T, options map[string]string) *types.ClusterInfo {
+	t.Helper()
+	s, err := getStateFromOpts(&types.DriverOptions{StringOptions: options})
+	if err != nil {
+		t.Fatal(err)
+	}
+	info := &types.ClusterInfo{}
+	if err := storeState(info, s); err != nil {
+		t.Fatal(err)
+	}
+	return info
+}
+
+func TestPostCheck(t *testing.T) {
+	tests := []struct {
+		name       string
+		options    map[string]string
+		gitVersion string
+		nodes      int
+		// noTokens leaves out the token controller, so service accounts never get a token
+		noTokens  bool
+		wantToken string
+		wantErr   string
+	}{
+		{
+			name:       "default identity",
+			options:    map[string]string{"name": "c1"},
+			gitVersion: "v1.10.5",
+			nodes:      3,
+			wantToken:  "token-of-netes-default",
+		},
+		{
+			name: "custom service account",
+			options: map[string]string{
+				"name":                      "c2",
+				"service-account-name":      "rancher",
+				"service-account-namespace": "kube-system",
+			},
+			gitVersion: "v1.11.1",
+			nodes:      1,
+			wantToken:  "token-of-rancher",
+		},
+		{
+			name:       "no nodes",
+			options:    map[string]string{"name": "c2"},
+			gitVersion: "v1.9.7",
+			wantToken:  "token-of-netes-default",
+		},
+		{
+			name:       "no token",
+			options:    map[string]string{"name": "c3"},
+			gitVersion: "v1.10.5",
+			noTokens:   true,
+			wantErr:    "error getting service account token",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			backend := &adminTokenBackend{}
+			clientset := newFakeClientset(test.gitVersion, test.nodes, !test.noTokens)
+			m := newPostCheckDriver(backend, clientset)
+
+			info, err := m.PostCheck(context.Background(), newPostCheckInfo(t, test.options))
+			if test.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+					t.Fatalf("error is %v, want one containing %q", err, test.wantErr)
+				}
+				if len(backend.revoked) != 0 {
+					t.Error("the admin token was revoked without a token to replace it")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if info.Version != test.gitVersion {
+				t.Errorf("version is %q, want %q", info.Version, test.gitVersion)
+			}
+			if info.NodeCount != int64(test.nodes) {
+				t.Errorf("node count is %d, want %d", info.NodeCount, test.nodes)
+			}
+			if info.ServiceAccountToken != test.wantToken {
+				t.Errorf("token is %q, want %q", info.ServiceAccountToken, test.wantToken)
+			}
+			if len(backend.revoked) != 1 {
+				t.Errorf("the admin token was revoked %d times, want once", len(backend.revoked))
+			}
+
+			stored, err := getState(info)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if stored.Bootstrap.Binding == "" {
+				t.Errorf("the binding of the service account is not recorded")
+			}
+			if _, err := clientset.RbacV1().ClusterRoleBindings().Get(stored.Bootstrap.Binding, metav1.GetOptions{}); err != nil {
+				t.Errorf("error getting the binding: %v", err)
+			}
+		})
+	}
+}
+
+// newLegacyInfo returns the cluster info of a cluster that got the netes-default binding before
+// the bootstrap identity was configurable, along with a clientset holding that binding
+func newLegacyInfo(t *testing.T) (*types.ClusterInfo, *fake.Clientset) {
+	t.Helper()
+	info := &types.ClusterInfo{
+		Metadata: map[string]string{stateKey: `{"SchemaVersion":7,"Name":"c1","NodePools":[{"Name":"default","Count":1}]}`},
+	}
+	clientset := newFakeClientset("v1.10.5", 1, true)
+	legacy := &rbacv1.ClusterRoleBinding{
+		ObjectMeta: metav1.ObjectMeta{Name: defaultBootstrapServiceAccount},
+		RoleRef:    rbacv1.RoleRef{Kind: "ClusterRole", Name: defaultBootstrapClusterRole, APIGroup: rbacv1.GroupName},
+	}
+	if _, err := clientset.RbacV1().ClusterRoleBindings().Create(legacy); err != nil {
+		t.Fatal(err)
+	}
+	return info, clientset
+}
+
+func TestLegacyBindingIsReplaced(t *testing.T) {
+	tests := []struct {
+		name string
+		call func(m *MyDriver, info *types.ClusterInfo) (*types.ClusterInfo, error)
+	}{
+		{
+			name: "post check",
+			call: func(m *MyDriver, info *types.ClusterInfo) (*types.ClusterInfo, error) {
+				return m.PostCheck(context.Background(), info)
+			},
+		},
+		{
+			name: "update",
+			call: func(m *MyDriver, info *types.ClusterInfo) (*types.ClusterInfo, error) {
+				return m.Update(context.Background(), info, &types.DriverOptions{})
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			info, clientset := newLegacyInfo(t)
+			m := newPostCheckDriver(&adminTokenBackend{}, clientset)
+
+			info, err := test.call(m, info)
+			if err != nil {
+				t.Fatal(err)
+			}
+			stored, err := getState(info)
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := "mydriver:default:netes-default"
+			if stored.Bootstrap.Binding != want {
+				t.Errorf("binding is %q, want %q", stored.Bootstrap.Binding, want)
+			}
+			if _, err := clientset.RbacV1().ClusterRoleBindings().Get(want, metav1.GetOptions{}); err != nil {
+				t.Errorf("error getting the new binding: %v", err)
+			}
+			if _, err := clientset.RbacV1().ClusterRoleBindings().Get(defaultBootstrapServiceAccount, metav1.GetOptions{}); err == nil {
+				t.Error("the legacy binding is still in place")
+			}
+			if info.ServiceAccountToken != "token-of-netes-default" {
+				t.Errorf("token is %q, want the token of netes-default", info.ServiceAccountToken)
+			}
+		})
+	}
+}
+
+func TestPostCheckRevokesAdminToken(t *testing.T) {
+	ctx := context.Background()
+	m := newMyDriver()
+	info := createCluster(t, m, &types.DriverOptions{StringOptions: map[string]string{"name": "c1"}})
+	defer m.Remove(ctx, info)
+	adminToken := info.ServiceAccountToken
+
+	info, err := m.PostCheck(ctx, info)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.ServiceAccountToken == "" || info.ServiceAccountToken == adminToken {
+		t.Fatalf("token is %q, want the token of the bootstrap service account", info.ServiceAccountToken)
+	}
+
+	// connect with nothing but a token
+	clientsetWith := func(token string) kubernetes.Interface {
+		clientset, err := newClientset(&types.ClusterInfo{
+			Endpoint:            info.Endpoint,
+			RootCaCertificate:   info.RootCaCertificate,
+			ServiceAccountToken: token,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return clientset
+	}
+	if _, err := clientsetWith(info.ServiceAccountToken).Discovery().ServerVersion(); err != nil {
+		t.Errorf("error using the bootstrap token: %v", err)
+	}
+	if _, err := clientsetWith(adminToken).Discovery().ServerVersion(); err == nil {
+		t.Error("the admin token still works")
+	}
+}
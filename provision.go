@@ -0,0 +1,245 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/rancher/kontainer-engine/types"
+)
+
+// createdCheckpoint is recorded once every create step of a cluster completed
+const createdCheckpoint = "created"
+
+// resumeOptionChecks report whether a create option of a retried create differs from what the
+// previous attempt recorded. The completed steps used the recorded options, so a retry has to
+// give the same ones.
+var resumeOptionChecks = map[string]func(fresh, previous state) bool{
+	"display-name": func(fresh, previous state) bool {
+		return fresh.DisplayName != previous.DisplayName
+	},
+	"kubernetes-version": func(fresh, previous state) bool {
+		return fresh.KubernetesVersion != previous.KubernetesVersion
+	},
+	"node-count": func(fresh, previous state) bool {
+		return defaultPoolOnly(fresh) && defaultPoolOnly(previous) && fresh.NodePools[0].Count != previous.NodePools[0].Count
+	},
+	"node-pools": func(fresh, previous state) bool {
+		if defaultPoolOnly(fresh) && defaultPoolOnly(previous) {
+			return false
+		}
+		if len(fresh.NodePools) != len(previous.NodePools) {
+			return true
+		}
+		for i, pool := range fresh.NodePools {
+			if pool.Count != previous.NodePools[i].Count || !samePoolLayout(pool, previous.NodePools[i]) {
+				return true
+			}
+		}
+		return false
+	},
+	"resize-policy": func(fresh, previous state) bool {
+		return fresh.ResizePolicy != previous.ResizePolicy
+	},
+	"resize-pool": func(fresh, previous state) bool {
+		return fresh.ResizePool != previous.ResizePool
+	},
+	"cluster-cidr": func(fresh, previous state) bool {
+		return fresh.ClusterCIDR != previous.ClusterCIDR
+	},
+	"service-cidr": func(fresh, previous state) bool {
+		return fresh.ServiceCIDR != previous.ServiceCIDR
+	},
+	"rollback-on-failure": func(fresh, previous state) bool {
+		return fresh.RollbackOnFailure != previous.RollbackOnFailure
+	},
+	"create-timeout": func(fresh, previous state) bool {
+		return fresh.Timeouts.Create != previous.Timeouts.Create
+	},
+	"update-timeout": func(fresh, previous state) bool {
+		return fresh.Timeouts.Update != previous.Timeouts.Update
+	},
+	"remove-timeout": func(fresh, previous state) bool {
+		return fresh.Timeouts.Remove != previous.Timeouts.Remove
+	},
+	"service-account-name": func(fresh, previous state) bool {
+		return fresh.Bootstrap.ServiceAccount != previous.Bootstrap.ServiceAccount
+	},
+	"service-account-namespace": func(fresh, previous state) bool {
+		return fresh.Bootstrap.Namespace != previous.Bootstrap.Namespace
+	},
+	"cluster-role": func(fresh, previous state) bool {
+		return fresh.Bootstrap.ClusterRole != previous.Bootstrap.ClusterRole
+	},
+	"cluster-role-rules": func(fresh, previous state) bool {
+		return !reflect.DeepEqual(fresh.Bootstrap.Rules, previous.Bootstrap.Rules)
+	},
+}
+
+func init() {
+	// a create option without a check could silently be dropped by a resumed create, the name
+	// is compared on its own
+	for _, field := range configFields {
+		if _, ok := resumeOptionChecks[field.Name]; !ok && field.Name != "name" && updateMode(field) != optionUpdateOnly {
+			panic(fmt.Sprintf("create option %s has no check in resumeOptionChecks", field.Name))
+		}
+	}
+}
+
+// defaultPoolOnly reports whether the cluster uses the single pool sized by node-count
+func defaultPoolOnly(s state) bool {
+	return len(s.NodePools) == 1 && s.NodePools[0].Name == defaultNodePoolName
+}
+
+// changedResumeOptions returns the sorted names of the create options a retried create changes
+func changedResumeOptions(fresh, previous state) []string {
+	var changed []string
+	for name, check := range resumeOptionChecks {
+		if check(fresh, previous) {
+			changed = append(changed, name)
+		}
+	}
+	sort.Strings(changed)
+	return changed
+}
+
+// resumeState returns the state a retried create continues from. Rancher passes the cluster
+// info of the failed attempt back to Create, anything else starts from scratch. A retry has to
+// give the options of the failed attempt.
+func (m *MyDriver) resumeState(fresh state, clusterInfo *types.ClusterInfo) (state, *types.ClusterInfo, error) {
+	if clusterInfo == nil || clusterInfo.Metadata[stateKey] == "" {
+		fresh.Backend = m.backend.Name()
+		return fresh, &types.ClusterInfo{}, nil
+	}
+
+	previous, err := getState(clusterInfo)
+	if err != nil {
+		return previous, nil, err
+	}
+	if previous.Name != fresh.Name {
+		return previous, nil, fmt.Errorf("cannot resume creating cluster %s, the previous attempt created cluster %s", fresh.Name, previous.Name)
+	}
+	if previous.Backend != m.backend.Name() {
+		return previous, nil, fmt.Errorf("cannot resume creating cluster %s, the previous attempt used the %s backend", fresh.Name, previous.Backend)
+	}
+	if changed := changedResumeOptions(fresh, previous); len(changed) > 0 {
+		return previous, nil, fmt.Errorf("cannot resume creating cluster %s, the following options differ from the previous attempt: %s",
+			fresh.Name, strings.Join(changed, ", "))
+	}
+	clusterInfo.CreateError = ""
+	return previous, clusterInfo, nil
+}
+
+// provision runs the create steps of the backend that did not complete yet, recording a
+// checkpoint in the cluster info after every step
+func (m *MyDriver) provision(ctx context.Context, state *state, info *types.ClusterInfo) error {
+	log := clusterLog(ctx, state.Name)
+	if state.completed(createdCheckpoint) {
+		log.Infof("cluster %s has already been created", state.Name)
+		return nil
+	}
+
+	// record the cluster before the first step so that even a failing first step leaves
+	// enough behind for Remove
+	if err := storeState(info, *state); err != nil {
+		return err
+	}
+
+	// undo holds the steps that may have left something behind, in the order they ran
+	var undo []createStep
+	steps := m.backend.CreateSteps()
+	for i, step := range steps {
+		undo = append(undo, step)
+		if state.completed(step.name) {
+			if step.done == nil || step.done(state) {
+				log.Infof("skipping step %s of cluster %s, a previous attempt completed it", step.name, state.Name)
+				continue
+			}
+			log.Infof("running step %s of cluster %s again, the work of a previous attempt is gone", step.name, state.Name)
+			// the later steps build on this one, so they run again as well
+			for _, later := range steps[i:] {
+				state.Checkpoints = removeCheckpoint(state.Checkpoints, later.name)
+			}
+			if err := storeState(info, *state); err != nil {
+				return err
+			}
+		}
+		operation := fmt.Sprintf("step %s of creating cluster %s", step.name, state.Name)
+		if err := contextError(ctx, operation); err != nil {
+			// the checkpoints reached so far are recorded, a retry continues from here
+			return err
+		}
+		log.Infof("running step %s of cluster %s", step.name, state.Name)
+		if err := step.run(ctx, state, info); err != nil {
+			if ctxErr := contextError(ctx, operation); ctxErr != nil {
+				return ctxErr
+			}
+			err = fmt.Errorf("error in step %s: %v", step.name, err)
+			if state.RollbackOnFailure {
+				return m.rollback(ctx, state, info, undo, err)
+			}
+			return err
+		}
+		state.Checkpoints = append(state.Checkpoints, step.name)
+		if err := storeState(info, *state); err != nil {
+			return err
+		}
+	}
+
+	state.Checkpoints = append(state.Checkpoints, createdCheckpoint)
+	info.Version = state.KubernetesVersion
+	info.NodeCount = totalNodeCount(state.NodePools)
+	return storeState(info, *state)
+}
+
+// rollback undoes steps in reverse order after a create failed with cause. The failed step is
+// undone as well since it may have done part of its work. Steps that could not be undone keep
+// their checkpoint and are reported in the returned error, which Rancher shows as the create error.
+func (m *MyDriver) rollback(ctx context.Context, state *state, info *types.ClusterInfo, steps []createStep, cause error) error {
+	log := clusterLog(ctx, state.Name)
+	log.Warnf("rolling back cluster %s: %v", state.Name, cause)
+
+	var failed []string
+	for i := len(steps) - 1; i >= 0; i-- {
+		step := steps[i]
+		if step.undo != nil {
+			log.Infof("undoing step %s of cluster %s", step.name, state.Name)
+			if err := step.undo(ctx, state, info); err != nil {
+				log.Warnf("error undoing step %s of cluster %s: %v", step.name, state.Name, err)
+				failed = append(failed, fmt.Sprintf("%s: %v", step.name, err))
+				continue
+			}
+		}
+		state.Checkpoints = removeCheckpoint(state.Checkpoints, step.name)
+	}
+	if err := storeState(info, *state); err != nil {
+		return fmt.Errorf("%v, error recording the rollback: %v", cause, err)
+	}
+
+	if len(failed) > 0 {
+		return fmt.Errorf("%v, rolling back failed and left these steps in place: %s", cause, strings.Join(failed, "; "))
+	}
+	log.Infof("rolled back cluster %s", state.Name)
+	return fmt.Errorf("%v, the completed steps were rolled back", cause)
+}
+
+// removeCheckpoint returns checkpoints without the given one
+func removeCheckpoint(checkpoints []string, checkpoint string) []string {
+	var kept []string
+	for _, c := range checkpoints {
+		if c != checkpoint {
+			kept = append(kept, c)
+		}
+	}
+	return kept
+}
+
+// checkpointSummary describes the steps a create completed for error messages
+func checkpointSummary(s state) string {
+	if len(s.Checkpoints) == 0 {
+		return "no steps"
+	}
+	return "steps " + strings.Join(s.Checkpoints, ", ")
+}
@@ -0,0 +1,375 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/rancher/kontainer-engine/types"
+)
+
+// fakeBackend has a create step for every name in steps. A step fails while fail holds an error
+// for it, its undo fails while undoFail holds one, and its work counts as gone while gone is set
+// for it.
+type fakeBackend struct {
+	bareBackend
+	steps    []string
+	fail     map[string]error
+	undoFail map[string]error
+	gone     map[string]bool
+	// ran and undone list the steps that ran and were undone, in order
+	ran    []string
+	undone []string
+}
+
+func newFakeBackend(steps ...string) *fakeBackend {
+	return &fakeBackend{
+		steps:    steps,
+		fail:     map[string]error{},
+		undoFail: map[string]error{},
+		gone:     map[string]bool{},
+	}
+}
+
+func (b *fakeBackend) Name() string { return "fake" }
+
+func (b *fakeBackend) CreateSteps() []createStep {
+	var steps []createStep
+	for _, name := range b.steps {
+		name := name
+		steps = append(steps, createStep{
+			name: name,
+			run: func(ctx context.Context, state *state, info *types.ClusterInfo) error {
+				b.ran = append(b.ran, name)
+				return b.fail[name]
+			},
+			undo: func(ctx context.Context, state *state, info *types.ClusterInfo) error {
+				b.undone = append(b.undone, name)
+				return b.undoFail[name]
+			},
+			done: func(state *state) bool {
+				return !b.gone[name]
+			},
+		})
+	}
+	return steps
+}
+
+func TestProvision(t *testing.T) {
+	tests := []struct {
+		name string
+		// failFirst is the step that fails in the first attempt
+		failFirst string
+		// gone are the steps whose work is gone before the retry
+		gone      []string
+		wantFirst []string
+		wantRetry []string
+	}{
+		{
+			name:      "every step completes",
+			wantFirst: []string{"a", "b", "c"},
+		},
+		{
+			name:      "a retry resumes at the failed step",
+			failFirst: "b",
+			wantFirst: []string{"a", "b"},
+			wantRetry: []string{"b", "c"},
+		},
+		{
+			name:      "a step whose work is gone runs again with every later step",
+			failFirst: "c",
+			gone:      []string{"a"},
+			wantFirst: []string{"a", "b", "c"},
+			wantRetry: []string{"a", "b", "c"},
+		},
+		{
+			name:      "a later step whose work is gone",
+			failFirst: "c",
+			gone:      []string{"b"},
+			wantFirst: []string{"a", "b", "c"},
+			wantRetry: []string{"b", "c"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			backend := newFakeBackend("a", "b", "c")
+			m := &MyDriver{backend: backend}
+			state := state{Name: "c1", Backend: backend.Name()}
+			info := &types.ClusterInfo{}
+
+			if test.failFirst != "" {
+				backend.fail[test.failFirst] = errors.New("boom")
+			}
+			err := m.provision(context.Background(), &state, info)
+			if !reflect.DeepEqual(backend.ran, test.wantFirst) {
+				t.Errorf("first attempt ran %v, want %v", backend.ran, test.wantFirst)
+			}
+			if test.failFirst == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !state.completed(createdCheckpoint) {
+					t.Errorf("checkpoints are %v, want the created checkpoint", state.Checkpoints)
+				}
+				return
+			}
+			if want := "error in step " + test.failFirst + ": boom"; err == nil || err.Error() != want {
+				t.Fatalf("error is %v, want %q", err, want)
+			}
+
+			// the retry continues from the state the first attempt stored in the cluster info
+			stored, err := getState(info)
+			if err != nil {
+				t.Fatal(err)
+			}
+			backend.ran = nil
+			backend.fail = map[string]error{}
+			for _, step := range test.gone {
+				backend.gone[step] = true
+			}
+			if err := m.provision(context.Background(), &stored, info); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(backend.ran, test.wantRetry) {
+				t.Errorf("retry ran %v, want %v", backend.ran, test.wantRetry)
+			}
+			if want := []string{"a", "b", "c", createdCheckpoint}; !reflect.DeepEqual(stored.Checkpoints, want) {
+				t.Errorf("checkpoints are %v, want %v", stored.Checkpoints, want)
+			}
+		})
+	}
+}
+
+func TestRollback(t *testing.T) {
+	tests := []struct {
+		name     string
+		rollback bool
+		// completed are the checkpoints of a previous attempt
+		completed       []string
+		undoFail        string
+		wantUndone      []string
+		wantCheckpoints []string
+		wantErr         string
+	}{
+		{
+			name:            "without rollback",
+			wantCheckpoints: []string{"a", "b"},
+			wantErr:         "error in step c: boom",
+		},
+		{
+			name:       "the failed step and the completed ones are undone in reverse order",
+			rollback:   true,
+			wantUndone: []string{"c", "b", "a"},
+			wantErr:    "error in step c: boom, the completed steps were rolled back",
+		},
+		{
+			name:       "steps of a previous attempt are undone as well",
+			rollback:   true,
+			completed:  []string{"a", "b"},
+			wantUndone: []string{"c", "b", "a"},
+			wantErr:    "error in step c: boom, the completed steps were rolled back",
+		},
+		{
+			name:            "a failed undo keeps its checkpoint and the rollback goes on",
+			rollback:        true,
+			undoFail:        "b",
+			wantUndone:      []string{"c", "b", "a"},
+			wantCheckpoints: []string{"b"},
+			wantErr:         "error in step c: boom, rolling back failed and left these steps in place: b: stuck",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			backend := newFakeBackend("a", "b", "c")
+			backend.fail["c"] = errors.New("boom")
+			if test.undoFail != "" {
+				backend.undoFail[test.undoFail] = errors.New("stuck")
+			}
+			m := &MyDriver{backend: backend}
+			state := state{Name: "c1", Backend: backend.Name(), RollbackOnFailure: test.rollback, Checkpoints: test.completed}
+			info := &types.ClusterInfo{}
+
+			err := m.provision(context.Background(), &state, info)
+			if err == nil || err.Error() != test.wantErr {
+				t.Fatalf("error is %v, want %q", err, test.wantErr)
+			}
+			if !reflect.DeepEqual(backend.undone, test.wantUndone) {
+				t.Errorf("undone steps are %v, want %v", backend.undone, test.wantUndone)
+			}
+			stored, err := getState(info)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(stored.Checkpoints, test.wantCheckpoints) {
+				t.Errorf("checkpoints are %v, want %v", stored.Checkpoints, test.wantCheckpoints)
+			}
+		})
+	}
+}
+
+func TestProvisionCreatedCluster(t *testing.T) {
+	backend := newFakeBackend("a")
+	m := &MyDriver{backend: backend}
+	state := state{Name: "c1", Checkpoints: []string{createdCheckpoint}}
+	if err := m.provision(context.Background(), &state, &types.ClusterInfo{}); err != nil {
+		t.Fatal(err)
+	}
+	if len(backend.ran) != 0 {
+		t.Errorf("ran %v for a created cluster", backend.ran)
+	}
+}
+
+func TestResumeState(t *testing.T) {
+	m := &MyDriver{backend: newFakeBackend("a")}
+	previous, err := getStateFromOpts(&types.DriverOptions{StringOptions: map[string]string{"name": "c1"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	previous.Backend = "fake"
+	previous.Checkpoints = []string{"a"}
+	info := &types.ClusterInfo{CreateError: "boom"}
+	if err := storeState(info, previous); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		opts    *types.DriverOptions
+		info    *types.ClusterInfo
+		resumed bool
+		wantErr string
+	}{
+		{name: "first attempt", opts: &types.DriverOptions{StringOptions: map[string]string{"name": "c1"}}},
+		{name: "first attempt with empty cluster info", opts: &types.DriverOptions{StringOptions: map[string]string{"name": "c1"}}, info: &types.ClusterInfo{}},
+		{name: "retry", opts: &types.DriverOptions{StringOptions: map[string]string{"name": "c1"}}, info: info, resumed: true},
+		{
+			name:    "another cluster",
+			opts:    &types.DriverOptions{StringOptions: map[string]string{"name": "c2"}},
+			info:    info,
+			wantErr: "cannot resume creating cluster c2, the previous attempt created cluster c1",
+		},
+		{
+			name: "changed options",
+			opts: &types.DriverOptions{
+				StringOptions: map[string]string{"name": "c1", "display-name": "Cluster 1", "cluster-cidr": "10.100.0.0/16"},
+				IntOptions:    map[string]int64{"node-count": 5},
+			},
+			info:    info,
+			wantErr: "cannot resume creating cluster c1, the following options differ from the previous attempt: cluster-cidr, display-name, node-count",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fresh, err := getStateFromOpts(test.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var clusterInfo *types.ClusterInfo
+			if test.info != nil {
+				copied := *test.info
+				clusterInfo = &copied
+			}
+
+			got, gotInfo, err := m.resumeState(fresh, clusterInfo)
+			if test.wantErr != "" {
+				if err == nil || err.Error() != test.wantErr {
+					t.Fatalf("error is %v, want %q", err, test.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if gotInfo == nil || gotInfo.CreateError != "" {
+				t.Errorf("cluster info is %+v, want one without the create error", gotInfo)
+			}
+			if got.Backend != "fake" {
+				t.Errorf("backend is %q, want fake", got.Backend)
+			}
+			if resumed := strings.Join(got.Checkpoints, ",") == "a"; resumed != test.resumed {
+				t.Errorf("checkpoints are %v, want resumed %v", got.Checkpoints, test.resumed)
+			}
+		})
+	}
+}
+
+func TestResumeStateBackend(t *testing.T) {
+	m := &MyDriver{backend: newFakeBackend("a")}
+	fresh := state{Name: "c1"}
+	info := &types.ClusterInfo{}
+	if err := storeState(info, state{Name: "c1", Backend: "other"}); err != nil {
+		t.Fatal(err)
+	}
+	want := "cannot resume creating cluster c1, the previous attempt used the other backend"
+	if _, _, err := m.resumeState(fresh, info); err == nil || err.Error() != want {
+		t.Errorf("error is %v, want %q", err, want)
+	}
+}
+
+func TestCreatePartialInfo(t *testing.T) {
+	tests := []struct {
+		name string
+		fail string
+		// wantCheckpoints are the checkpoints of the state in the returned cluster info
+		wantCheckpoints []string
+	}{
+		{name: "first step fails", fail: "a"},
+		{name: "later step fails", fail: "c", wantCheckpoints: []string{"a", "b"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			backend := newFakeBackend("a", "b", "c")
+			backend.fail[test.fail] = errors.New("boom")
+			m := &MyDriver{backend: backend}
+			opts := &types.DriverOptions{StringOptions: map[string]string{"name": "c1"}}
+
+			info, err := m.Create(context.Background(), opts, &types.ClusterInfo{})
+			if err == nil {
+				t.Fatal("create passed")
+			}
+			if info == nil {
+				t.Fatal("create returned no cluster info")
+			}
+			state, err := getState(info)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if state.Name != "c1" || state.Backend != "fake" || !reflect.DeepEqual(state.Checkpoints, test.wantCheckpoints) {
+				t.Errorf("state is %+v, want cluster c1 on the fake backend with checkpoints %v", state, test.wantCheckpoints)
+			}
+
+			// Rancher records the error in the cluster info it hands to the retry
+			info.CreateError = "boom"
+			backend.ran = nil
+			backend.fail = map[string]error{}
+			info, err = m.Create(context.Background(), opts, info)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := []string{"a", "b", "c"}[len(test.wantCheckpoints):]; !reflect.DeepEqual(backend.ran, want) {
+				t.Errorf("retry ran %v, want %v", backend.ran, want)
+			}
+			if info.CreateError != "" {
+				t.Errorf("create error %q is still set", info.CreateError)
+			}
+		})
+	}
+}
+
+func TestCreateInvalidOptions(t *testing.T) {
+	m := &MyDriver{backend: newFakeBackend("a")}
+	clusterInfo := &types.ClusterInfo{}
+	info, err := m.Create(context.Background(), &types.DriverOptions{}, clusterInfo)
+	if err == nil {
+		t.Fatal("create without a name passed")
+	}
+	if info != clusterInfo {
+		t.Errorf("cluster info is %+v, want the one create was given", info)
+	}
+}
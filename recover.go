@@ -0,0 +1,50 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"runtime/debug"
+
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// recoverPanics turns a panic in an RPC into a codes.Internal error instead of crashing the
+// process and every operation in flight with it. The stack is logged under an error ID that the
+// error carries, along with the log-id of the request.
+func recoverPanics(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (resp interface{}, err error) {
+	defer func() {
+		r := recover()
+		if r == nil {
+			return
+		}
+		errorID := newErrorID()
+		fields := logrus.Fields{
+			"error-id": errorID,
+			"method":   info.FullMethod,
+		}
+		if logID := requestLogID(ctx); logID != "" {
+			fields["log-id"] = logID
+		}
+		logrus.WithFields(fields).Errorf("panic in %s: %v\n%s", info.FullMethod, r, debug.Stack())
+		if stream := logStream(ctx); stream != nil {
+			stream.Warnf("mydriver failed with an internal error, error ID %s", errorID)
+		}
+
+		resp = nil
+		err = status.Errorf(codes.Internal, "internal error in %s, error ID %s", info.FullMethod, errorID)
+	}()
+	return handler(ctx, req)
+}
+
+// newErrorID returns a random ID to find the log of an internal error by
+func newErrorID() string {
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return "unknown"
+	}
+	return hex.EncodeToString(id)
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/rancher/kontainer-engine/types"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// panicDriver panics in GetDriverCreateOptions and answers GetDriverUpdateOptions
+type panicDriver struct{ types.Driver }
+
+func (panicDriver) GetDriverCreateOptions(ctx context.Context) (*types.DriverFlags, error) {
+	panic("create options are broken")
+}
+
+func (panicDriver) GetDriverUpdateOptions(ctx context.Context) (*types.DriverFlags, error) {
+	return &types.DriverFlags{}, nil
+}
+
+func TestRecoverPanics(t *testing.T) {
+	s := newRPCServer(panicDriver{}, rpcServerOptions{})
+	defer s.Shutdown(0, 0)
+	conn, err := grpc.Dial(serveLocal(t, s), grpc.WithInsecure())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	client := types.NewDriverClient(conn)
+
+	_, err = client.GetDriverCreateOptions(context.Background(), &types.Empty{})
+	if errorCode(err) != codes.Internal {
+		t.Fatalf("the panicking RPC got %v, want code %s", err, codes.Internal)
+	}
+	st, _ := status.FromError(err)
+	if !strings.HasPrefix(st.Message(), "internal error in /types.Driver/GetDriverCreateOptions, error ID ") {
+		t.Errorf("error message is %q, want it to name the method and an error ID", st.Message())
+	}
+
+	// the process and the connection survive the panic, and it is no longer tracked as in flight
+	if _, err := client.GetDriverUpdateOptions(context.Background(), &types.Empty{}); err != nil {
+		t.Errorf("an RPC after the panic got %v", err)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.inFlight) != 0 {
+		t.Errorf("%d RPCs are still in flight", len(s.inFlight))
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/rancher/kontainer-engine/types"
+)
+
+const redacted = "<redacted>"
+
+// sensitiveKeyWords mark option and metadata keys whose values must not be logged. None of the
+// options driverConfig declares is secret, but Rancher passes options of other drivers through
+// as is, so values are recognized by their key.
+var sensitiveKeyWords = []string{"password", "secret", "token", "credential", "private", "key"}
+
+// isSensitiveKey reports whether the value of an option or metadata key must be redacted
+func isSensitiveKey(key string) bool {
+	lower := strings.ToLower(key)
+	for _, word := range sensitiveKeyWords {
+		if strings.Contains(lower, word) {
+			return true
+		}
+	}
+	return false
+}
+
+// redactOptions formats driver options for logging with every sensitive value masked
+func redactOptions(opts *types.DriverOptions) fmt.Stringer {
+	return redactedOptions{opts}
+}
+
+type redactedOptions struct {
+	opts *types.DriverOptions
+}
+
+func (r redactedOptions) String() string {
+	if r.opts == nil {
+		return "<nil>"
+	}
+	masked := &types.DriverOptions{
+		BoolOptions:        r.opts.BoolOptions,
+		IntOptions:         r.opts.IntOptions,
+		StringOptions:      map[string]string{},
+		StringSliceOptions: map[string]*types.StringSlice{},
+	}
+	for key, value := range r.opts.StringOptions {
+		if value != "" && isSensitiveKey(key) {
+			value = redacted
+		}
+		masked.StringOptions[key] = value
+	}
+	for key, value := range r.opts.StringSliceOptions {
+		if value != nil && isSensitiveKey(key) {
+			values := make([]string, len(value.Value))
+			for i := range values {
+				values[i] = redacted
+			}
+			value = &types.StringSlice{Value: values}
+		}
+		masked.StringSliceOptions[key] = value
+	}
+	return masked.String()
+}
+
+// redactClusterInfo formats cluster info for logging with the credentials and every sensitive
+// metadata value masked
+func redactClusterInfo(info *types.ClusterInfo) fmt.Stringer {
+	return redactedClusterInfo{info}
+}
+
+type redactedClusterInfo struct {
+	info *types.ClusterInfo
+}
+
+func (r redactedClusterInfo) String() string {
+	if r.info == nil {
+		return "<nil>"
+	}
+	masked := *r.info
+	for _, field := range []*string{&masked.Password, &masked.ClientKey, &masked.ServiceAccountToken} {
+		if *field != "" {
+			*field = redacted
+		}
+	}
+	if r.info.Metadata != nil {
+		masked.Metadata = map[string]string{}
+		for key, value := range r.info.Metadata {
+			if value != "" && isSensitiveKey(key) {
+				value = redacted
+			}
+			masked.Metadata[key] = value
+		}
+	}
+	return masked.String()
+}
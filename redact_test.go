@@ -0,0 +1,68 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/rancher/kontainer-engine/types"
+)
+
+func TestRedactOptions(t *testing.T) {
+	opts := &types.DriverOptions{
+		StringOptions: map[string]string{
+			"name":        "c1",
+			"accessToken": "s3cr3t-token",
+			"password":    "s3cr3t-password",
+			"privateKey":  "",
+		},
+		StringSliceOptions: map[string]*types.StringSlice{
+			"node-pools": {Value: []string{"name=a,count=1"}},
+			"apiKeys":    {Value: []string{"s3cr3t-key-1", "s3cr3t-key-2"}},
+		},
+		IntOptions: map[string]int64{"node-count": 3},
+	}
+
+	got := redactOptions(opts).String()
+	if strings.Contains(got, "s3cr3t") {
+		t.Errorf("redacted options leak a secret: %s", got)
+	}
+	for _, want := range []string{"c1", "name=a,count=1", redacted} {
+		if !strings.Contains(got, want) {
+			t.Errorf("redacted options %s do not contain %q", got, want)
+		}
+	}
+	if opts.StringOptions["password"] != "s3cr3t-password" || opts.StringSliceOptions["apiKeys"].Value[0] != "s3cr3t-key-1" {
+		t.Errorf("redacting changed the options")
+	}
+	if got := redactOptions(nil).String(); got != "<nil>" {
+		t.Errorf("redacted nil options are %q, want <nil>", got)
+	}
+}
+
+func TestRedactClusterInfo(t *testing.T) {
+	info := &types.ClusterInfo{
+		Endpoint:            "https://127.0.0.1:6443",
+		Username:            "admin",
+		Password:            "s3cr3t-password",
+		ClientKey:           "s3cr3t-client-key",
+		ServiceAccountToken: "s3cr3t-token",
+		Metadata: map[string]string{
+			"name":        "c1",
+			"token-hash":  "s3cr3t-hash",
+			"credentials": "s3cr3t-credentials",
+		},
+	}
+
+	got := redactClusterInfo(info).String()
+	if strings.Contains(got, "s3cr3t") {
+		t.Errorf("redacted cluster info leaks a secret: %s", got)
+	}
+	for _, want := range []string{"https://127.0.0.1:6443", "admin", "c1", redacted} {
+		if !strings.Contains(got, want) {
+			t.Errorf("redacted cluster info %s does not contain %q", got, want)
+		}
+	}
+	if info.ServiceAccountToken != "s3cr3t-token" || info.Metadata["token-hash"] != "s3cr3t-hash" {
+		t.Errorf("redacting changed the cluster info")
+	}
+}
@@ -0,0 +1,195 @@
+package schema
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rancher/kontainer-engine/types"
+)
+
+// FieldError is a problem with a single option
+type FieldError struct {
+	Option  string
+	Message string
+}
+
+func (e FieldError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Option, e.Message)
+}
+
+// Errors collects every problem found in a set of options
+type Errors []FieldError
+
+func (e Errors) Error() string {
+	messages := make([]string, 0, len(e))
+	for _, err := range e {
+		messages = append(messages, err.Error())
+	}
+	return fmt.Sprintf("invalid options: %s", strings.Join(messages, "; "))
+}
+
+// Decoder decodes driver options into a tagged struct
+type Decoder struct {
+	// SkipDefaults leaves unset options at their zero value, updates use this so that only
+	// the options that were actually given are applied
+	SkipDefaults bool
+	// SkipRequired does not complain about unset required options
+	SkipRequired bool
+}
+
+// Decode decodes opts into the struct v points to, applying defaults and enforcing required options
+func Decode(opts *types.DriverOptions, v interface{}) error {
+	return Decoder{}.Decode(opts, v)
+}
+
+// Decode decodes opts into the struct v points to. All problems are returned together as Errors
+func (d Decoder) Decode(opts *types.DriverOptions, v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() {
+		return fmt.Errorf("schema: decode needs a non nil pointer to a struct, got %T", v)
+	}
+	fields, err := Fields(v)
+	if err != nil {
+		return err
+	}
+	if opts == nil {
+		opts = &types.DriverOptions{}
+	}
+
+	var errs Errors
+	for _, field := range fields {
+		target := rv.Elem().FieldByIndex(field.index)
+		set, err := decodeOption(opts, field, target)
+		if err != nil {
+			errs = append(errs, FieldError{Option: field.Name, Message: err.Error()})
+			continue
+		}
+		if set {
+			continue
+		}
+		if field.Default != "" && !d.SkipDefaults {
+			if err := decodeString(field.Default, target); err != nil {
+				errs = append(errs, FieldError{Option: field.Name, Message: fmt.Sprintf("invalid default %q: %v", field.Default, err)})
+			}
+			continue
+		}
+		if field.Required && !d.SkipRequired {
+			errs = append(errs, FieldError{Option: field.Name, Message: "is required"})
+		}
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// IsSet reports whether any key of the field is present in opts, an empty string counts as unset
+func IsSet(opts *types.DriverOptions, field Field) bool {
+	if opts == nil {
+		return false
+	}
+	for _, key := range field.Keys() {
+		var ok bool
+		switch field.Type {
+		case types.StringType:
+			ok = opts.StringOptions[key] != ""
+		case types.IntType:
+			_, ok = opts.IntOptions[key]
+		case types.BoolType, types.BoolPointerType:
+			_, ok = opts.BoolOptions[key]
+		case types.StringSliceType:
+			_, ok = opts.StringSliceOptions[key]
+		}
+		if ok {
+			return true
+		}
+	}
+	return false
+}
+
+// decodeOption copies the first key of the field found in opts into target and reports whether one
+// was found. An empty string is not an option value, it leaves the option unset whatever its type.
+func decodeOption(opts *types.DriverOptions, field Field, target reflect.Value) (bool, error) {
+	for _, key := range field.Keys() {
+		switch field.Type {
+		case types.StringType:
+			if value := opts.StringOptions[key]; value != "" {
+				return true, decodeString(value, target)
+			}
+		case types.IntType:
+			if value, ok := opts.IntOptions[key]; ok {
+				if target.OverflowInt(value) {
+					return true, fmt.Errorf("%d is out of range", value)
+				}
+				target.SetInt(value)
+				return true, nil
+			}
+		case types.BoolType:
+			if value, ok := opts.BoolOptions[key]; ok {
+				target.SetBool(value)
+				return true, nil
+			}
+		case types.BoolPointerType:
+			if value, ok := opts.BoolOptions[key]; ok {
+				target.Set(reflect.ValueOf(&value))
+				return true, nil
+			}
+		case types.StringSliceType:
+			if value, ok := opts.StringSliceOptions[key]; ok {
+				target.Set(reflect.ValueOf(append([]string{}, value.GetValue()...)))
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
+
+// decodeString parses the textual form used by defaults and string options into target
+func decodeString(value string, target reflect.Value) error {
+	if target.Type() == durationType {
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return fmt.Errorf("%q is not a duration", value)
+		}
+		target.SetInt(int64(d))
+		return nil
+	}
+
+	switch target.Kind() {
+	case reflect.String:
+		target.SetString(value)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("%q is not a boolean", value)
+		}
+		target.SetBool(b)
+	case reflect.Ptr:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("%q is not a boolean", value)
+		}
+		target.Set(reflect.ValueOf(&b))
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || target.OverflowInt(n) {
+			return fmt.Errorf("%q is not a valid integer", value)
+		}
+		target.SetInt(n)
+	case reflect.Slice:
+		var values []string
+		for _, v := range strings.Split(value, ",") {
+			if v = strings.TrimSpace(v); v != "" {
+				values = append(values, v)
+			}
+		}
+		target.Set(reflect.ValueOf(values))
+	default:
+		return fmt.Errorf("unsupported type %s", target.Type())
+	}
+	return nil
+}
@@ -0,0 +1,258 @@
+package schema
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/rancher/kontainer-engine/types"
+)
+
+type decodeConfig struct {
+	Name     string        `option:"name,required"`
+	Count    int64         `option:"node-count,alias=nodeCount,alias=count" default:"3"`
+	Small    int8          `option:"small"`
+	Enabled  bool          `option:"enabled,alias=isEnabled"`
+	Optional *bool         `option:"optional" default:"true"`
+	Timeout  time.Duration `option:"timeout" default:"30m"`
+	Tags     []string      `option:"tags" default:"a, b,,c"`
+}
+
+func boolPtr(b bool) *bool {
+	return &b
+}
+
+func TestDecode(t *testing.T) {
+	tests := []struct {
+		name    string
+		decoder Decoder
+		opts    *types.DriverOptions
+		want    decodeConfig
+		wantErr error
+	}{
+		{
+			name: "defaults",
+			opts: &types.DriverOptions{StringOptions: map[string]string{"name": "c1"}},
+			want: decodeConfig{
+				Name:     "c1",
+				Count:    3,
+				Optional: boolPtr(true),
+				Timeout:  30 * time.Minute,
+				Tags:     []string{"a", "b", "c"},
+			},
+		},
+		{
+			name: "every option set",
+			opts: &types.DriverOptions{
+				StringOptions:      map[string]string{"name": "c1", "timeout": "1h30m"},
+				IntOptions:         map[string]int64{"node-count": 5, "small": -128},
+				BoolOptions:        map[string]bool{"enabled": true, "optional": false},
+				StringSliceOptions: map[string]*types.StringSlice{"tags": {Value: []string{"x"}}},
+			},
+			want: decodeConfig{
+				Name:     "c1",
+				Count:    5,
+				Small:    -128,
+				Enabled:  true,
+				Optional: boolPtr(false),
+				Timeout:  90 * time.Minute,
+				Tags:     []string{"x"},
+			},
+		},
+		{
+			name:    "aliases",
+			decoder: Decoder{SkipDefaults: true},
+			opts: &types.DriverOptions{
+				StringOptions: map[string]string{"name": "c1"},
+				IntOptions:    map[string]int64{"count": 7},
+				BoolOptions:   map[string]bool{"isEnabled": true},
+			},
+			want: decodeConfig{Name: "c1", Count: 7, Enabled: true},
+		},
+		{
+			name:    "the option name wins over its aliases",
+			decoder: Decoder{SkipDefaults: true},
+			opts: &types.DriverOptions{
+				StringOptions: map[string]string{"name": "c1"},
+				IntOptions:    map[string]int64{"count": 7, "nodeCount": 6, "node-count": 5},
+				BoolOptions:   map[string]bool{"isEnabled": false, "enabled": true},
+			},
+			want: decodeConfig{Name: "c1", Count: 5, Enabled: true},
+		},
+		{
+			name:    "earlier aliases win over later ones",
+			decoder: Decoder{SkipDefaults: true},
+			opts: &types.DriverOptions{
+				StringOptions: map[string]string{"name": "c1"},
+				IntOptions:    map[string]int64{"count": 7, "nodeCount": 6},
+			},
+			want: decodeConfig{Name: "c1", Count: 6},
+		},
+		{
+			name: "a zero value is set, not defaulted",
+			opts: &types.DriverOptions{StringOptions: map[string]string{"name": "c1", "timeout": "0s"}, IntOptions: map[string]int64{"nodeCount": 0}},
+			want: decodeConfig{Name: "c1", Optional: boolPtr(true), Tags: []string{"a", "b", "c"}},
+		},
+		{
+			name: "an empty string is unset",
+			opts: &types.DriverOptions{StringOptions: map[string]string{"name": "", "timeout": ""}},
+			want: decodeConfig{
+				Count:    3,
+				Optional: boolPtr(true),
+				Timeout:  30 * time.Minute,
+				Tags:     []string{"a", "b", "c"},
+			},
+			wantErr: Errors{{Option: "name", Message: "is required"}},
+		},
+		{
+			name:    "options of another type are ignored",
+			decoder: Decoder{SkipDefaults: true},
+			opts:    &types.DriverOptions{StringOptions: map[string]string{"name": "c1", "node-count": "5"}},
+			want:    decodeConfig{Name: "c1"},
+		},
+		{
+			name:    "skip defaults",
+			decoder: Decoder{SkipDefaults: true},
+			opts:    &types.DriverOptions{StringOptions: map[string]string{"name": "c1"}},
+			want:    decodeConfig{Name: "c1"},
+		},
+		{
+			name:    "required",
+			opts:    &types.DriverOptions{},
+			want:    decodeConfig{Count: 3, Optional: boolPtr(true), Timeout: 30 * time.Minute, Tags: []string{"a", "b", "c"}},
+			wantErr: Errors{{Option: "name", Message: "is required"}},
+		},
+		{
+			name:    "nil options",
+			want:    decodeConfig{Count: 3, Optional: boolPtr(true), Timeout: 30 * time.Minute, Tags: []string{"a", "b", "c"}},
+			wantErr: Errors{{Option: "name", Message: "is required"}},
+		},
+		{
+			name:    "skip required",
+			decoder: Decoder{SkipDefaults: true, SkipRequired: true},
+			opts:    &types.DriverOptions{},
+		},
+		{
+			name:    "integer overflow",
+			decoder: Decoder{SkipDefaults: true},
+			opts: &types.DriverOptions{
+				StringOptions: map[string]string{"name": "c1"},
+				IntOptions:    map[string]int64{"small": 128},
+			},
+			want:    decodeConfig{Name: "c1"},
+			wantErr: Errors{{Option: "small", Message: "128 is out of range"}},
+		},
+		{
+			name:    "negative integer overflow",
+			decoder: Decoder{SkipDefaults: true},
+			opts: &types.DriverOptions{
+				StringOptions: map[string]string{"name": "c1"},
+				IntOptions:    map[string]int64{"small": -129},
+			},
+			want:    decodeConfig{Name: "c1"},
+			wantErr: Errors{{Option: "small", Message: "-129 is out of range"}},
+		},
+		{
+			name:    "every problem is reported",
+			decoder: Decoder{SkipDefaults: true},
+			opts: &types.DriverOptions{
+				StringOptions: map[string]string{"timeout": "soon"},
+				IntOptions:    map[string]int64{"small": 1000},
+			},
+			wantErr: Errors{
+				{Option: "name", Message: "is required"},
+				{Option: "small", Message: "1000 is out of range"},
+				{Option: "timeout", Message: `"soon" is not a duration`},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var got decodeConfig
+			err := test.decoder.Decode(test.opts, &got)
+			if !reflect.DeepEqual(err, test.wantErr) {
+				t.Fatalf("error is %#v, want %#v", err, test.wantErr)
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("decoded\n%+v\nwant\n%+v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestDecodeCopiesSlices(t *testing.T) {
+	tags := []string{"a", "b"}
+	opts := &types.DriverOptions{
+		StringOptions:      map[string]string{"name": "c1"},
+		StringSliceOptions: map[string]*types.StringSlice{"tags": {Value: tags}},
+	}
+	var config decodeConfig
+	if err := Decode(opts, &config); err != nil {
+		t.Fatal(err)
+	}
+	config.Tags[0] = "changed"
+	if tags[0] != "a" {
+		t.Errorf("decoding shares the slice of the options")
+	}
+}
+
+func TestDecodeInvalidDefault(t *testing.T) {
+	var config struct {
+		Count   int           `option:"count" default:"many"`
+		Timeout time.Duration `option:"timeout" default:"soon"`
+		Enabled bool          `option:"enabled" default:"maybe"`
+	}
+	want := Errors{
+		{Option: "count", Message: `invalid default "many": "many" is not a valid integer`},
+		{Option: "timeout", Message: `invalid default "soon": "soon" is not a duration`},
+		{Option: "enabled", Message: `invalid default "maybe": "maybe" is not a boolean`},
+	}
+	if err := Decode(nil, &config); !reflect.DeepEqual(err, want) {
+		t.Errorf("error is %#v, want %#v", err, want)
+	}
+}
+
+func TestDecodeNeedsAStructPointer(t *testing.T) {
+	var config decodeConfig
+	for _, v := range []interface{}{config, (*decodeConfig)(nil), nil} {
+		err := Decode(&types.DriverOptions{}, v)
+		if err == nil {
+			t.Errorf("decoding into %T passed", v)
+			continue
+		}
+		if _, ok := err.(Errors); ok {
+			t.Errorf("decoding into %T failed with option errors: %v", v, err)
+		}
+	}
+}
+
+func TestIsSet(t *testing.T) {
+	fields, err := Fields(decodeConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	name, count := fields[0], fields[1]
+
+	tests := []struct {
+		name  string
+		field Field
+		opts  *types.DriverOptions
+		want  bool
+	}{
+		{name: "nil options", field: count},
+		{name: "unset", field: count, opts: &types.DriverOptions{IntOptions: map[string]int64{"small": 1}}},
+		{name: "set by name", field: count, opts: &types.DriverOptions{IntOptions: map[string]int64{"node-count": 0}}, want: true},
+		{name: "set by alias", field: count, opts: &types.DriverOptions{IntOptions: map[string]int64{"count": 0}}, want: true},
+		{name: "option of another type", field: count, opts: &types.DriverOptions{StringOptions: map[string]string{"node-count": "1"}}},
+		{name: "string", field: name, opts: &types.DriverOptions{StringOptions: map[string]string{"name": "c1"}}, want: true},
+		{name: "empty string", field: name, opts: &types.DriverOptions{StringOptions: map[string]string{"name": ""}}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := IsSet(test.opts, test.field); got != test.want {
+				t.Errorf("IsSet is %v, want %v", got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,182 @@
+// Package schema derives kontainer-engine driver flags from a tagged config struct and
+// decodes driver options back into it, so an option is declared exactly once.
+//
+// Every exported field with an option tag becomes a driver option:
+//
+//	type config struct {
+//		Name     string        `option:"name,required" usage:"The internal name of the cluster"`
+//		Count    int64         `option:"node-count,alias=nodeCount" default:"3" usage:"The number of nodes"`
+//		Password string        `option:"password,secret" usage:"The admin password"`
+//		Timeout  time.Duration `option:"timeout" default:"30m" usage:"How long to wait"`
+//	}
+//
+// The option tag holds the option name followed by any of alias=NAME (repeatable),
+// type=TYPE, required and secret. The flag type is inferred from the field type unless
+// given: string and time.Duration map to string, integers to int, bool to bool, *bool to
+// boolPtr and []string to stringSlice. Fields keep their whole tag in Field.Tag so callers
+// can attach their own metadata.
+package schema
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/rancher/kontainer-engine/types"
+)
+
+const tagName = "option"
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// Field describes a single driver option
+type Field struct {
+	// Name is the primary option name
+	Name string
+	// Aliases are alternative names the option is read from, Rancher sends camel case keys
+	Aliases []string
+	// Type is one of the flag types in the kontainer-engine types package
+	Type string
+	// Usage is the help text of the option
+	Usage string
+	// Default is the value used when the option is not set
+	Default string
+	// Required options must be set
+	Required bool
+	// Secret options must never be logged
+	Secret bool
+	// Tag is the full struct tag of the field
+	Tag reflect.StructTag
+
+	index []int
+}
+
+// Keys returns the primary name followed by the aliases
+func (f Field) Keys() []string {
+	return append([]string{f.Name}, f.Aliases...)
+}
+
+// Fields returns the options declared by a struct or pointer to struct, in field order
+func Fields(v interface{}) ([]Field, error) {
+	t := reflect.TypeOf(v)
+	if t != nil && t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t == nil || t.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("schema: %T is not a struct", v)
+	}
+
+	var fields []Field
+	seen := map[string]string{}
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		tag, ok := sf.Tag.Lookup(tagName)
+		if !ok || tag == "-" {
+			continue
+		}
+		field, err := parseField(sf, tag)
+		if err != nil {
+			return nil, err
+		}
+		for _, key := range field.Keys() {
+			if other, ok := seen[key]; ok {
+				return nil, fmt.Errorf("schema: option %s is declared by both %s and %s", key, other, sf.Name)
+			}
+			seen[key] = sf.Name
+		}
+		fields = append(fields, field)
+	}
+	return fields, nil
+}
+
+func parseField(sf reflect.StructField, tag string) (Field, error) {
+	parts := strings.Split(tag, ",")
+	field := Field{
+		Name:    strings.TrimSpace(parts[0]),
+		Usage:   sf.Tag.Get("usage"),
+		Default: sf.Tag.Get("default"),
+		Tag:     sf.Tag,
+		index:   sf.Index,
+	}
+	if field.Name == "" {
+		return field, fmt.Errorf("schema: field %s has no option name", sf.Name)
+	}
+	if sf.PkgPath != "" {
+		return field, fmt.Errorf("schema: field %s is not exported", sf.Name)
+	}
+
+	for _, part := range parts[1:] {
+		part = strings.TrimSpace(part)
+		switch {
+		case part == "required":
+			field.Required = true
+		case part == "secret":
+			field.Secret = true
+		case strings.HasPrefix(part, "alias="):
+			field.Aliases = append(field.Aliases, strings.TrimPrefix(part, "alias="))
+		case strings.HasPrefix(part, "type="):
+			field.Type = strings.TrimPrefix(part, "type=")
+		default:
+			return field, fmt.Errorf("schema: field %s has unknown option tag %q", sf.Name, part)
+		}
+	}
+
+	inferred, err := inferType(sf.Type)
+	if err != nil {
+		return field, fmt.Errorf("schema: field %s: %v", sf.Name, err)
+	}
+	if field.Type == "" {
+		field.Type = inferred
+	} else if field.Type != inferred {
+		return field, fmt.Errorf("schema: field %s of type %s cannot hold a %s option", sf.Name, sf.Type, field.Type)
+	}
+	return field, nil
+}
+
+func inferType(t reflect.Type) (string, error) {
+	if t == durationType {
+		return types.StringType, nil
+	}
+	switch t.Kind() {
+	case reflect.String:
+		return types.StringType, nil
+	case reflect.Bool:
+		return types.BoolType, nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return types.IntType, nil
+	case reflect.Ptr:
+		if t.Elem().Kind() == reflect.Bool {
+			return types.BoolPointerType, nil
+		}
+	case reflect.Slice:
+		if t.Elem().Kind() == reflect.String {
+			return types.StringSliceType, nil
+		}
+	}
+	return "", fmt.Errorf("unsupported type %s", t)
+}
+
+// Flags builds the driver flags of every option declared by v
+func Flags(v interface{}) (*types.DriverFlags, error) {
+	fields, err := Fields(v)
+	if err != nil {
+		return nil, err
+	}
+	return FlagsFor(fields), nil
+}
+
+// FlagsFor builds the driver flags of the given fields
+func FlagsFor(fields []Field) *types.DriverFlags {
+	driverFlag := types.DriverFlags{
+		Options: make(map[string]*types.Flag),
+	}
+	for _, field := range fields {
+		driverFlag.Options[field.Name] = &types.Flag{
+			Type:  field.Type,
+			Usage: field.Usage,
+			Value: field.Default,
+		}
+	}
+	return &driverFlag
+}
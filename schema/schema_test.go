@@ -0,0 +1,174 @@
+package schema
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rancher/kontainer-engine/types"
+)
+
+func TestFields(t *testing.T) {
+	type valid struct {
+		Name     string        `option:"name,required" usage:"The name"`
+		Count    int64         `option:"node-count,alias=nodeCount,alias=count" default:"3"`
+		Small    int32         `option:"small,type=int"`
+		Password string        `option:"password,secret"`
+		Enabled  bool          `option:"enabled"`
+		Optional *bool         `option:"optional"`
+		Timeout  time.Duration `option:"timeout" default:"30m"`
+		Tags     []string      `option:"tags"`
+		Ignored  string        `option:"-"`
+		Untagged string
+	}
+
+	tests := []struct {
+		name    string
+		v       interface{}
+		want    []Field
+		wantErr string
+	}{
+		{
+			name: "infers types and parses the tag",
+			v:    valid{},
+			want: []Field{
+				{Name: "name", Type: types.StringType, Usage: "The name", Required: true},
+				{Name: "node-count", Aliases: []string{"nodeCount", "count"}, Type: types.IntType, Default: "3"},
+				{Name: "small", Type: types.IntType},
+				{Name: "password", Type: types.StringType, Secret: true},
+				{Name: "enabled", Type: types.BoolType},
+				{Name: "optional", Type: types.BoolPointerType},
+				{Name: "timeout", Type: types.StringType, Default: "30m"},
+				{Name: "tags", Type: types.StringSliceType},
+			},
+		},
+		{
+			name: "pointer to struct",
+			v: &struct {
+				Name string `option:"name"`
+			}{},
+			want: []Field{{Name: "name", Type: types.StringType}},
+		},
+		{
+			name: "no options",
+			v: struct {
+				Name string
+			}{},
+		},
+		{
+			name: "duplicate option",
+			v: struct {
+				A string `option:"name"`
+				B string `option:"name"`
+			}{},
+			wantErr: "option name is declared by both A and B",
+		},
+		{
+			name: "alias of one option is the name of another",
+			v: struct {
+				A string `option:"a,alias=b"`
+				B string `option:"b"`
+			}{},
+			wantErr: "option b is declared by both A and B",
+		},
+		{
+			name: "duplicate alias",
+			v: struct {
+				A string `option:"a,alias=x"`
+				B string `option:"b,alias=x"`
+			}{},
+			wantErr: "option x is declared by both A and B",
+		},
+		{
+			name: "unknown tag",
+			v: struct {
+				A string `option:"a,optional"`
+			}{},
+			wantErr: `field A has unknown option tag "optional"`,
+		},
+		{
+			name: "type mismatch",
+			v: struct {
+				A string `option:"a,type=int"`
+			}{},
+			wantErr: "field A of type string cannot hold a int option",
+		},
+		{
+			name: "bool pointer declared as bool",
+			v: struct {
+				A *bool `option:"a,type=bool"`
+			}{},
+			wantErr: "field A of type *bool cannot hold a bool option",
+		},
+		{
+			name: "unsupported type",
+			v: struct {
+				A float64 `option:"a"`
+			}{},
+			wantErr: "field A: unsupported type float64",
+		},
+		{
+			name: "unexported field",
+			v: struct {
+				a string `option:"a"`
+			}{},
+			wantErr: "field a is not exported",
+		},
+		{
+			name: "no option name",
+			v: struct {
+				A string `option:",required"`
+			}{},
+			wantErr: "field A has no option name",
+		},
+		{
+			name:    "not a struct",
+			v:       "name",
+			wantErr: "string is not a struct",
+		},
+		{
+			name:    "nil",
+			v:       nil,
+			wantErr: "<nil> is not a struct",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fields, err := Fields(test.v)
+			if test.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+					t.Fatalf("error is %v, want one containing %q", err, test.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// the tag and the index are internals of the struct, compare the rest
+			var got []Field
+			for _, field := range fields {
+				field.Tag = ""
+				field.index = nil
+				got = append(got, field)
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("fields are\n%+v\nwant\n%+v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestFieldsKeepTheTag(t *testing.T) {
+	fields, err := Fields(struct {
+		Name string `option:"name" update:"immutable"`
+	}{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := fields[0].Tag.Get("update"); got != "immutable" {
+		t.Errorf("update tag is %q, want immutable", got)
+	}
+}
@@ -0,0 +1,149 @@
+package schema
+
+import (
+	"fmt"
+	"net"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+// formats are the value formats the format tag can require
+var formats = map[string]func(value string) error{
+	"cidr": func(value string) error {
+		if _, _, err := net.ParseCIDR(value); err != nil {
+			return fmt.Errorf("%q is not a valid CIDR", value)
+		}
+		return nil
+	},
+	"dns-label": func(value string) error {
+		if errs := validation.IsDNS1123Label(value); len(errs) > 0 {
+			return fmt.Errorf("%q is not a valid DNS label: %s", value, strings.Join(errs, ", "))
+		}
+		return nil
+	},
+	"label": ValidateLabel,
+}
+
+// ValidateFormat checks value against one of the formats the format tag knows
+func ValidateFormat(format, value string) error {
+	check, ok := formats[format]
+	if !ok {
+		return fmt.Errorf("unknown format %q", format)
+	}
+	return check(value)
+}
+
+// ValidateLabel checks a Kubernetes label of the form key=value
+func ValidateLabel(label string) error {
+	kv := strings.SplitN(label, "=", 2)
+	if len(kv) != 2 {
+		return fmt.Errorf("label %q is not of the form key=value", label)
+	}
+	if errs := validation.IsQualifiedName(kv[0]); len(errs) > 0 {
+		return fmt.Errorf("label %q has an invalid key: %s", label, strings.Join(errs, ", "))
+	}
+	if errs := validation.IsValidLabelValue(kv[1]); len(errs) > 0 {
+		return fmt.Errorf("label %q has an invalid value: %s", label, strings.Join(errs, ", "))
+	}
+	return nil
+}
+
+// Validate checks the declarative rules of the options of the struct v points to:
+//
+//	enum:"a,b,c"    the value has to be one of the listed values
+//	min:"N" max:"N" inclusive bounds of an integer option, durations such as 10m for a duration option
+//	format:"NAME"   the value, or every element of a slice, has to be a cidr, dns-label or label
+//
+// Unset string options are not checked, so the same rules work for create and update. All
+// problems are returned together as Errors.
+func Validate(v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() == reflect.Ptr {
+		rv = rv.Elem()
+	}
+	fields, err := Fields(v)
+	if err != nil {
+		return err
+	}
+
+	var errs Errors
+	for _, field := range fields {
+		value := rv.FieldByIndex(field.index)
+		for _, message := range validateField(field, value) {
+			errs = append(errs, FieldError{Option: field.Name, Message: message})
+		}
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+func validateField(field Field, value reflect.Value) []string {
+	var messages []string
+
+	if enum := field.Tag.Get("enum"); enum != "" && value.Kind() == reflect.String && value.String() != "" {
+		allowed := strings.Split(enum, ",")
+		found := false
+		for _, a := range allowed {
+			found = found || a == value.String()
+		}
+		if !found {
+			messages = append(messages, fmt.Sprintf("%q is not one of %s", value.String(), strings.Join(allowed, ", ")))
+		}
+	}
+
+	if value.Kind() >= reflect.Int && value.Kind() <= reflect.Int64 && value.Type() != durationType {
+		if min, ok := field.Tag.Lookup("min"); ok {
+			if n, err := strconv.ParseInt(min, 10, 64); err == nil && value.Int() < n {
+				messages = append(messages, fmt.Sprintf("%d is less than the minimum of %d", value.Int(), n))
+			}
+		}
+		if max, ok := field.Tag.Lookup("max"); ok {
+			if n, err := strconv.ParseInt(max, 10, 64); err == nil && value.Int() > n {
+				messages = append(messages, fmt.Sprintf("%d is more than the maximum of %d", value.Int(), n))
+			}
+		}
+	}
+
+	if value.Type() == durationType {
+		d := time.Duration(value.Int())
+		if min, ok := field.Tag.Lookup("min"); ok {
+			if n, err := time.ParseDuration(min); err == nil && d < n {
+				messages = append(messages, fmt.Sprintf("%s is less than the minimum of %s", d, n))
+			}
+		}
+		if max, ok := field.Tag.Lookup("max"); ok {
+			if n, err := time.ParseDuration(max); err == nil && d > n {
+				messages = append(messages, fmt.Sprintf("%s is more than the maximum of %s", d, n))
+			}
+		}
+	}
+
+	if name := field.Tag.Get("format"); name != "" {
+		check, ok := formats[name]
+		if !ok {
+			return append(messages, fmt.Sprintf("unknown format %q", name))
+		}
+		var values []string
+		switch value.Kind() {
+		case reflect.String:
+			values = []string{value.String()}
+		case reflect.Slice:
+			values = value.Interface().([]string)
+		}
+		for _, v := range values {
+			if v == "" {
+				continue
+			}
+			if err := check(v); err != nil {
+				messages = append(messages, err.Error())
+			}
+		}
+	}
+	return messages
+}
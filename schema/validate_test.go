@@ -0,0 +1,174 @@
+package schema
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+type validateConfig struct {
+	Name    string        `option:"name" format:"dns-label"`
+	Policy  string        `option:"policy" enum:"first,last"`
+	Count   int64         `option:"count" min:"1" max:"10"`
+	Timeout time.Duration `option:"timeout" min:"1s" max:"1h"`
+	CIDR    string        `option:"cidr" format:"cidr"`
+	Labels  []string      `option:"labels" format:"label"`
+}
+
+func TestValidate(t *testing.T) {
+	valid := validateConfig{
+		Name:    "c1",
+		Policy:  "first",
+		Count:   3,
+		Timeout: time.Minute,
+		CIDR:    "10.42.0.0/16",
+		Labels:  []string{"role=worker", "example.com/zone=a"},
+	}
+
+	tests := []struct {
+		name   string
+		change func(c *validateConfig)
+		want   Errors
+	}{
+		{
+			name:   "valid",
+			change: func(c *validateConfig) {},
+		},
+		{
+			name: "unset strings are not checked",
+			change: func(c *validateConfig) {
+				c.Name, c.Policy, c.CIDR, c.Labels = "", "", "", nil
+			},
+		},
+		{
+			name:   "inclusive bounds",
+			change: func(c *validateConfig) { c.Count, c.Timeout = 10, time.Hour },
+		},
+		{
+			name:   "enum",
+			change: func(c *validateConfig) { c.Policy = "middle" },
+			want:   Errors{{Option: "policy", Message: `"middle" is not one of first, last`}},
+		},
+		{
+			name:   "integer below the minimum",
+			change: func(c *validateConfig) { c.Count = 0 },
+			want:   Errors{{Option: "count", Message: "0 is less than the minimum of 1"}},
+		},
+		{
+			name:   "integer above the maximum",
+			change: func(c *validateConfig) { c.Count = 11 },
+			want:   Errors{{Option: "count", Message: "11 is more than the maximum of 10"}},
+		},
+		{
+			name:   "duration below the minimum",
+			change: func(c *validateConfig) { c.Timeout = 500 * time.Millisecond },
+			want:   Errors{{Option: "timeout", Message: "500ms is less than the minimum of 1s"}},
+		},
+		{
+			name:   "duration above the maximum",
+			change: func(c *validateConfig) { c.Timeout = 2 * time.Hour },
+			want:   Errors{{Option: "timeout", Message: "2h0m0s is more than the maximum of 1h0m0s"}},
+		},
+		{
+			name:   "cidr",
+			change: func(c *validateConfig) { c.CIDR = "10.42.0.0" },
+			want:   Errors{{Option: "cidr", Message: `"10.42.0.0" is not a valid CIDR`}},
+		},
+		{
+			name:   "every element of a slice",
+			change: func(c *validateConfig) { c.Labels = []string{"role", "ok=yes", "=x", "a=b c"} },
+			want: Errors{
+				{Option: "labels", Message: `label "role" is not of the form key=value`},
+				{Option: "labels", Message: `label "=x" has an invalid key`},
+				{Option: "labels", Message: `label "a=b c" has an invalid value`},
+			},
+		},
+		{
+			name: "every problem is reported",
+			change: func(c *validateConfig) {
+				c.Name = "Not_A_Label"
+				c.Policy = "middle"
+				c.Count = 20
+				c.Timeout = 0
+				c.CIDR = "nope"
+				c.Labels = []string{"role"}
+			},
+			want: Errors{
+				{Option: "name", Message: `"Not_A_Label" is not a valid DNS label`},
+				{Option: "policy", Message: `"middle" is not one of first, last`},
+				{Option: "count", Message: "20 is more than the maximum of 10"},
+				{Option: "timeout", Message: "0s is less than the minimum of 1s"},
+				{Option: "cidr", Message: `"nope" is not a valid CIDR`},
+				{Option: "labels", Message: `label "role" is not of the form key=value`},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			config := valid
+			config.Labels = append([]string{}, valid.Labels...)
+			test.change(&config)
+
+			err := Validate(&config)
+			if len(test.want) == 0 {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			errs, ok := err.(Errors)
+			if !ok {
+				t.Fatalf("error is %#v, want Errors", err)
+			}
+			if len(errs) != len(test.want) {
+				t.Fatalf("got %d errors, want %d: %v", len(errs), len(test.want), err)
+			}
+			// the messages of the Kubernetes validation helpers are long, compare their start
+			for i, want := range test.want {
+				if errs[i].Option != want.Option || !strings.HasPrefix(errs[i].Message, want.Message) {
+					t.Errorf("error %d is %q, want %q", i, errs[i], want)
+				}
+			}
+		})
+	}
+}
+
+func TestValidateUnknownFormat(t *testing.T) {
+	config := struct {
+		Name string `option:"name" format:"hostname"`
+	}{Name: "c1"}
+	want := Errors{{Option: "name", Message: `unknown format "hostname"`}}
+	if err := Validate(&config); !reflect.DeepEqual(err, want) {
+		t.Errorf("error is %#v, want %#v", err, want)
+	}
+}
+
+func TestValidateFormat(t *testing.T) {
+	tests := []struct {
+		format  string
+		value   string
+		wantErr bool
+	}{
+		{format: "cidr", value: "10.0.0.0/8"},
+		{format: "cidr", value: "fd00::/64"},
+		{format: "cidr", value: "10.0.0.1", wantErr: true},
+		{format: "dns-label", value: "my-cluster-1"},
+		{format: "dns-label", value: "My-Cluster", wantErr: true},
+		{format: "dns-label", value: "-cluster", wantErr: true},
+		{format: "dns-label", value: strings.Repeat("a", 64), wantErr: true},
+		{format: "label", value: "example.com/role=worker"},
+		{format: "label", value: "role="},
+		{format: "label", value: "role", wantErr: true},
+		{format: "label", value: "role=a/b", wantErr: true},
+		{format: "hostname", value: "c1", wantErr: true},
+	}
+
+	for _, test := range tests {
+		err := ValidateFormat(test.format, test.value)
+		if (err != nil) != test.wantErr {
+			t.Errorf("ValidateFormat(%q, %q) returned %v, want error %v", test.format, test.value, err, test.wantErr)
+		}
+	}
+}
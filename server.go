@@ -0,0 +1,213 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/rancher/kontainer-engine/types"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/reflection"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// defaultDrainTimeout is how long a shutdown waits for in-flight RPCs to finish on their own
+	defaultDrainTimeout = 2 * time.Minute
+	// defaultAbortTimeout is how long a shutdown waits for canceled RPCs to record their checkpoints
+	defaultAbortTimeout = 15 * time.Second
+)
+
+// rpcServer serves a driver like types.GrpcServer.Serve does, but keeps track of the RPCs in
+// flight so that a shutdown can let them finish instead of cutting them off
+type rpcServer struct {
+	grpcServer *grpc.Server
+	health     *healthServer
+	stopHealth context.CancelFunc
+
+	mu       sync.Mutex
+	draining bool
+	inFlight map[*rpcCall]struct{}
+	idle     *sync.Cond
+}
+
+// rpcCall is an RPC in flight
+type rpcCall struct {
+	method string
+	cancel context.CancelFunc
+}
+
+// rpcServerOptions secure the server
+type rpcServerOptions struct {
+	// TLS serves over TLS, nil serves without transport security
+	TLS *tls.Config
+	// Token is the bearer token every RPC has to present, empty accepts any caller
+	Token string
+	// HealthChecks are reported by the health service, see healthServer
+	HealthChecks map[string]healthCheck
+}
+
+func newRPCServer(driver types.Driver, opts rpcServerOptions) *rpcServer {
+	s := &rpcServer{
+		health:   newHealthServer(opts.HealthChecks),
+		inFlight: map[*rpcCall]struct{}{},
+	}
+	s.idle = sync.NewCond(&s.mu)
+	// health checks are answered while the server starts and drains, and supervisors probing
+	// it do not know the token
+	interceptors := []grpc.UnaryServerInterceptor{exceptMethod(healthCheckMethod, s.track)}
+	serverOptions := []grpc.ServerOption{}
+	if opts.Token != "" {
+		interceptors = append([]grpc.UnaryServerInterceptor{exceptMethod(healthCheckMethod, tokenAuth(opts.Token))}, interceptors...)
+		serverOptions = append(serverOptions, grpc.StreamInterceptor(tokenAuthStream(opts.Token)))
+	}
+	// a panic anywhere below, the interceptors included, fails only the RPC that hit it
+	interceptors = append([]grpc.UnaryServerInterceptor{recoverPanics}, interceptors...)
+	serverOptions = append(serverOptions, grpc.UnaryInterceptor(chainUnaryInterceptors(interceptors...)))
+	if opts.TLS != nil {
+		serverOptions = append(serverOptions, grpc.Creds(credentials.NewTLS(opts.TLS)))
+	}
+	s.grpcServer = grpc.NewServer(serverOptions...)
+	types.RegisterDriverServer(s.grpcServer, types.NewServer(driver, nil))
+	healthpb.RegisterHealthServer(s.grpcServer, s.health)
+	reflection.Register(s.grpcServer)
+	return s
+}
+
+// chainUnaryInterceptors runs interceptors in order around the handler, the vendored grpc takes
+// a single interceptor only
+func chainUnaryInterceptors(interceptors ...grpc.UnaryServerInterceptor) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		for i := len(interceptors) - 1; i >= 0; i-- {
+			interceptor, next := interceptors[i], handler
+			handler = func(ctx context.Context, req interface{}) (interface{}, error) {
+				return interceptor(ctx, req, info, next)
+			}
+		}
+		return handler(ctx, req)
+	}
+}
+
+// exceptMethod applies interceptor to every method but method
+func exceptMethod(method string, interceptor grpc.UnaryServerInterceptor) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if info.FullMethod == method {
+			return handler(ctx, req)
+		}
+		return interceptor(ctx, req, info, handler)
+	}
+}
+
+// Serve accepts connections on listener until the server shuts down
+func (s *rpcServer) Serve(listener net.Listener) error {
+	return s.grpcServer.Serve(listener)
+}
+
+// MarkReady runs the health checks and reports the driver SERVING once they pass, the checks
+// then run periodically until the server shuts down
+func (s *rpcServer) MarkReady() {
+	ctx, cancel := context.WithCancel(context.Background())
+	s.mu.Lock()
+	if s.draining {
+		s.mu.Unlock()
+		cancel()
+		return
+	}
+	s.stopHealth = cancel
+	s.mu.Unlock()
+
+	s.health.runChecks(ctx)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.draining {
+		return
+	}
+	s.health.setReady(true)
+	go s.health.monitor(ctx, healthCheckInterval)
+}
+
+// track records an RPC while it runs, RPCs arriving during a shutdown are refused
+func (s *rpcServer) track(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	call := &rpcCall{method: info.FullMethod, cancel: cancel}
+
+	s.mu.Lock()
+	if s.draining {
+		s.mu.Unlock()
+		cancel()
+		return nil, status.Errorf(codes.Unavailable, "mydriver is shutting down")
+	}
+	s.inFlight[call] = struct{}{}
+	s.mu.Unlock()
+
+	defer func() {
+		cancel()
+		s.mu.Lock()
+		delete(s.inFlight, call)
+		if len(s.inFlight) == 0 {
+			s.idle.Broadcast()
+		}
+		s.mu.Unlock()
+	}()
+	return handler(ctx, req)
+}
+
+// Shutdown refuses new RPCs and waits up to drainTimeout for the ones in flight. Those still
+// running are canceled then, which makes a create stop at the next step and return the
+// checkpoints it reached, and get abortTimeout to do so before their connections are closed.
+// The listener stays open until then so that health checks keep answering NOT_SERVING.
+func (s *rpcServer) Shutdown(drainTimeout, abortTimeout time.Duration) {
+	s.mu.Lock()
+	s.draining = true
+	s.health.setReady(false)
+	if s.stopHealth != nil {
+		s.stopHealth()
+	}
+	if n := len(s.inFlight); n > 0 {
+		logrus.Infof("waiting up to %s for %d RPCs in flight to finish", drainTimeout, n)
+	}
+	s.mu.Unlock()
+
+	// GracefulStop drains the connections right away and the gRPC client of Rancher fails the
+	// streams still open on a drained connection, so it only runs once every RPC was answered
+	if !s.waitIdle(drainTimeout) {
+		s.mu.Lock()
+		for call := range s.inFlight {
+			logrus.Warnf("canceling %s, it did not finish within %s", call.method, drainTimeout)
+			call.cancel()
+		}
+		s.mu.Unlock()
+		if !s.waitIdle(abortTimeout) {
+			logrus.Warnf("RPCs still running after %s, closing their connections", abortTimeout)
+			s.grpcServer.Stop()
+			return
+		}
+	}
+	s.grpcServer.GracefulStop()
+}
+
+// waitIdle waits until no RPC is in flight and reports whether that happened before timeout
+func (s *rpcServer) waitIdle(timeout time.Duration) bool {
+	idle := make(chan struct{})
+	go func() {
+		s.mu.Lock()
+		for len(s.inFlight) > 0 {
+			s.idle.Wait()
+		}
+		s.mu.Unlock()
+		close(idle)
+	}()
+
+	select {
+	case <-idle:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
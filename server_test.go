@@ -0,0 +1,182 @@
+package main
+
+import (
+	"context"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/rancher/kontainer-engine/types"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// removeBehaviors tell how a Remove of the blockingDriver behaves
+const (
+	// removeFinishes waits for the test to release it, whether canceled or not
+	removeFinishes = "finishes"
+	// removeStops returns once its context is canceled
+	removeStops = "stops"
+	// removeHangs ignores its context and only returns when the test ends
+	removeHangs = "hangs"
+)
+
+// blockingDriver serves Remove calls that block as the behavior metadata of the cluster info
+// says and records what happens to them in events
+type blockingDriver struct {
+	types.Driver
+	started chan struct{}
+	release chan struct{}
+
+	mu     sync.Mutex
+	events []string
+}
+
+func (d *blockingDriver) record(event string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.events = append(d.events, event)
+}
+
+func (d *blockingDriver) Remove(ctx context.Context, info *types.ClusterInfo) error {
+	d.started <- struct{}{}
+	switch info.Metadata["behavior"] {
+	case removeFinishes:
+		<-d.release
+	case removeStops:
+		<-ctx.Done()
+		d.record("canceled")
+		return ctx.Err()
+	case removeHangs:
+		<-d.release
+	}
+	d.record("finished")
+	return nil
+}
+
+// serveLocal serves s on a local port and returns its address
+func serveLocal(t *testing.T, s *rpcServer) string {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.Serve(listener)
+	return listener.Addr().String()
+}
+
+// errorCode returns the gRPC code of err, codes.OK for nil
+func errorCode(err error) codes.Code {
+	if st, ok := status.FromError(err); ok {
+		return st.Code()
+	}
+	return codes.Unknown
+}
+
+// waitDraining waits until s started shutting down
+func waitDraining(t *testing.T, s *rpcServer) {
+	t.Helper()
+	for i := 0; i < 1000; i++ {
+		s.mu.Lock()
+		draining := s.draining
+		s.mu.Unlock()
+		if draining {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatal("the server did not start draining")
+}
+
+func TestShutdown(t *testing.T) {
+	tests := []struct {
+		name         string
+		behavior     string
+		drainTimeout time.Duration
+		abortTimeout time.Duration
+		wantEvents   []string
+		wantCode     codes.Code
+	}{
+		{
+			name:         "an RPC in flight finishes before the server stops",
+			behavior:     removeFinishes,
+			drainTimeout: 10 * time.Second,
+			abortTimeout: 10 * time.Second,
+			wantEvents:   []string{"finished", "stopped"},
+			wantCode:     codes.OK,
+		},
+		{
+			name:         "an RPC over the drain timeout is canceled before the server stops",
+			behavior:     removeStops,
+			drainTimeout: 50 * time.Millisecond,
+			abortTimeout: 10 * time.Second,
+			wantEvents:   []string{"canceled", "stopped"},
+			wantCode:     codes.Canceled,
+		},
+		{
+			name:         "an RPC ignoring the cancellation is cut off",
+			behavior:     removeHangs,
+			drainTimeout: 50 * time.Millisecond,
+			abortTimeout: 50 * time.Millisecond,
+			wantEvents:   []string{"stopped"},
+			wantCode:     codes.Unavailable,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			driver := &blockingDriver{started: make(chan struct{}, 1), release: make(chan struct{})}
+			s := newRPCServer(driver, rpcServerOptions{})
+			conn, err := grpc.Dial(serveLocal(t, s), grpc.WithInsecure())
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+			client := types.NewDriverClient(conn)
+
+			removed := make(chan error, 1)
+			go func() {
+				_, err := client.Remove(context.Background(), &types.ClusterInfo{Metadata: map[string]string{"behavior": test.behavior}})
+				removed <- err
+			}()
+			<-driver.started
+
+			stopped := make(chan struct{})
+			go func() {
+				s.Shutdown(test.drainTimeout, test.abortTimeout)
+				driver.record("stopped")
+				close(stopped)
+			}()
+			waitDraining(t, s)
+
+			// RPCs arriving during the shutdown are refused
+			_, err = client.Remove(context.Background(), &types.ClusterInfo{Metadata: map[string]string{"behavior": removeFinishes}})
+			if errorCode(err) != codes.Unavailable {
+				t.Errorf("an RPC during the shutdown got %v, want it refused as unavailable", err)
+			}
+
+			if test.behavior == removeFinishes {
+				close(driver.release)
+			} else {
+				defer close(driver.release)
+			}
+			<-stopped
+			if err := <-removed; errorCode(err) != test.wantCode {
+				t.Errorf("the RPC in flight got %v, want code %s", err, test.wantCode)
+			}
+
+			driver.mu.Lock()
+			defer driver.mu.Unlock()
+			if len(driver.events) != len(test.wantEvents) {
+				t.Fatalf("events are %v, want %v", driver.events, test.wantEvents)
+			}
+			for i := range driver.events {
+				if driver.events[i] != test.wantEvents[i] {
+					t.Fatalf("events are %v, want %v", driver.events, test.wantEvents)
+				}
+			}
+		})
+	}
+}
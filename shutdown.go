@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// parentPollInterval is how often the parent process is checked for
+const parentPollInterval = time.Second
+
+// shutdownSignals returns a channel that receives the reason to shut down: SIGTERM or SIGINT,
+// the death of the parent process, or stdin being closed when it is a pipe
+func shutdownSignals() <-chan string {
+	reasons := make(chan string, 3)
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT)
+	go func() {
+		sig := <-signals
+		reasons <- fmt.Sprintf("received %s", sig)
+	}()
+
+	// a process whose parent is init was either started that way or orphaned already, there
+	// is no parent to watch
+	if parent := os.Getppid(); parent > 1 {
+		go func() {
+			watchParent(parent)
+			reasons <- fmt.Sprintf("parent process %d exited", parent)
+		}()
+	}
+
+	// Rancher starts drivers with stdin on /dev/null, a pipe only shows up when the parent
+	// hands one over to be told when it goes away
+	if stdinIsPipe() {
+		go func() {
+			io.Copy(ioutil.Discard, os.Stdin)
+			reasons <- "stdin was closed"
+		}()
+	}
+	return reasons
+}
+
+// watchParent returns once parent is no longer the parent process, orphans are reparented to
+// init or the closest subreaper
+func watchParent(parent int) {
+	for os.Getppid() == parent {
+		time.Sleep(parentPollInterval)
+	}
+}
+
+func stdinIsPipe() bool {
+	info, err := os.Stdin.Stat()
+	if err != nil {
+		return false
+	}
+	return info.Mode()&os.ModeNamedPipe != 0
+}
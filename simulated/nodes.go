@@ -0,0 +1,102 @@
+package simulated
+
+import (
+	"sort"
+)
+
+// Node describes a simulated cluster node
+type Node struct {
+	Name   string
+	Labels map[string]string
+	Taints []Taint
+}
+
+// Taint is a node taint
+type Taint struct {
+	Key    string
+	Value  string
+	Effect string
+}
+
+// SetNodes makes the set of registered nodes match nodes, any other node is deleted
+func (s *Server) SetNodes(nodes []Node) error {
+	wanted := map[string]bool{}
+	for _, node := range nodes {
+		wanted[node.Name] = true
+		obj := nodeObject(node, s.Version())
+		if _, err := s.store.get(nodeResource, "", node.Name); err == nil {
+			if _, err := s.store.update(nodeResource, "", obj); err != nil {
+				return err
+			}
+			continue
+		}
+		if _, err := s.store.create(nodeResource, "", obj); err != nil {
+			return err
+		}
+	}
+
+	for _, obj := range s.store.list(nodeResource, "") {
+		if !wanted[obj.name()] {
+			if _, err := s.store.delete(nodeResource, "", obj.name()); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Nodes returns the names of the registered nodes
+func (s *Server) Nodes() []string {
+	var names []string
+	for _, obj := range s.store.list(nodeResource, "") {
+		names = append(names, obj.name())
+	}
+	sort.Strings(names)
+	return names
+}
+
+// CountNodes returns the number of registered nodes for every value of the given label
+func (s *Server) CountNodes(label string) map[string]int64 {
+	counts := map[string]int64{}
+	for _, obj := range s.store.list(nodeResource, "") {
+		labels, _ := obj.metadata()["labels"].(map[string]interface{})
+		if value, ok := labels[label].(string); ok {
+			counts[value]++
+		}
+	}
+	return counts
+}
+
+func nodeObject(node Node, kubeletVersion string) object {
+	labels := map[string]interface{}{
+		"kubernetes.io/hostname": node.Name,
+	}
+	for k, v := range node.Labels {
+		labels[k] = v
+	}
+	var taints []interface{}
+	for _, taint := range node.Taints {
+		taints = append(taints, map[string]interface{}{
+			"key":    taint.Key,
+			"value":  taint.Value,
+			"effect": taint.Effect,
+		})
+	}
+	return object{
+		"metadata": map[string]interface{}{
+			"name":   node.Name,
+			"labels": labels,
+		},
+		"spec": map[string]interface{}{
+			"taints": taints,
+		},
+		"status": map[string]interface{}{
+			"conditions": []interface{}{
+				map[string]interface{}{"type": "Ready", "status": "True", "reason": "KubeletReady"},
+			},
+			"nodeInfo": map[string]interface{}{
+				"kubeletVersion": kubeletVersion,
+			},
+		},
+	}
+}
@@ -0,0 +1,508 @@
+// Package simulated implements a minimal, in-process Kubernetes API server. It
+// serves just enough of the API for Rancher to provision and manage a cluster
+// without any cloud account, which makes it handy for local development.
+package simulated
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/version"
+)
+
+const (
+	// DefaultVersion is the Kubernetes version reported when none is given
+	DefaultVersion = "v1.10.5"
+
+	adminNamespace      = "kube-system"
+	adminServiceAccount = "kube-admin"
+)
+
+// Server is a simulated Kubernetes API server for a single cluster
+type Server struct {
+	sync.Mutex
+	name     string
+	version  string
+	certs    *Certificates
+	store    *store
+	listener net.Listener
+	server   *http.Server
+}
+
+// NewServer creates a simulated API server for the named cluster using the given key material
+func NewServer(name, version string, certs *Certificates) *Server {
+	if version == "" {
+		version = DefaultVersion
+	}
+	return &Server{
+		name:    name,
+		version: version,
+		certs:   certs,
+		store:   newStore(),
+	}
+}
+
+// Start starts serving HTTPS on the given address, use "127.0.0.1:0" for an ephemeral port
+func (s *Server) Start(address string) error {
+	s.Lock()
+	defer s.Unlock()
+	if s.listener != nil {
+		return nil
+	}
+
+	tlsConfig, err := s.certs.serverTLSConfig()
+	if err != nil {
+		return err
+	}
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return fmt.Errorf("error listening on %s: %v", address, err)
+	}
+
+	for _, namespace := range []string{"default", adminNamespace} {
+		if _, err := s.store.create(namespaceResource, "", object{"metadata": map[string]interface{}{"name": namespace}}); err != nil && !isAlreadyExists(err) {
+			listener.Close()
+			return err
+		}
+	}
+	if _, err := s.store.create(clusterRoleResource, "", clusterAdminRole()); err != nil && !isAlreadyExists(err) {
+		listener.Close()
+		return err
+	}
+
+	server := &http.Server{Handler: s.authenticate(http.HandlerFunc(s.serveHTTP))}
+	s.listener = listener
+	s.server = server
+	go func() {
+		if err := server.Serve(tls.NewListener(listener, tlsConfig)); err != nil && err != http.ErrServerClosed {
+			logrus.Errorf("simulated api server for cluster %s stopped: %v", s.name, err)
+		}
+	}()
+	return nil
+}
+
+// Stop stops the server, it can be started again afterwards
+func (s *Server) Stop() error {
+	s.Lock()
+	defer s.Unlock()
+	if s.server == nil {
+		return nil
+	}
+	err := s.server.Close()
+	s.server = nil
+	s.listener = nil
+	return err
+}
+
+// Endpoint returns the https URL the server is listening on
+func (s *Server) Endpoint() string {
+	s.Lock()
+	defer s.Unlock()
+	if s.listener == nil {
+		return ""
+	}
+	return "https://" + s.listener.Addr().String()
+}
+
+// Version returns the Kubernetes version the server reports
+func (s *Server) Version() string {
+	s.Lock()
+	defer s.Unlock()
+	return s.version
+}
+
+// SetVersion changes the Kubernetes version the server and its nodes report
+func (s *Server) SetVersion(version string) error {
+	s.Lock()
+	s.version = version
+	s.Unlock()
+
+	for _, node := range s.store.list(nodeResource, "") {
+		updated := node.clone()
+		status, _ := updated["status"].(map[string]interface{})
+		if nodeInfo, ok := status["nodeInfo"].(map[string]interface{}); ok {
+			nodeInfo["kubeletVersion"] = version
+		}
+		if _, err := s.store.update(nodeResource, "", updated); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ServingVersion asks the running server for its version over HTTPS the way a client would,
+// which fails until the server accepts connections
+func (s *Server) ServingVersion(ctx context.Context) (string, error) {
+	endpoint := s.Endpoint()
+	if endpoint == "" {
+		return "", fmt.Errorf("simulated api server for cluster %s is not running", s.name)
+	}
+	tlsConfig, err := s.certs.clientTLSConfig()
+	if err != nil {
+		return "", err
+	}
+	transport := &http.Transport{TLSClientConfig: tlsConfig}
+	defer transport.CloseIdleConnections()
+
+	req, err := http.NewRequest(http.MethodGet, endpoint+"/version", nil)
+	if err != nil {
+		return "", err
+	}
+	resp, err := (&http.Client{Transport: transport}).Do(req.WithContext(ctx))
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("simulated api server for cluster %s answered %s", s.name, resp.Status)
+	}
+	info := version.Info{}
+	if err := json.NewDecoder(resp.Body).Decode(&info); err != nil {
+		return "", fmt.Errorf("error decoding version of simulated api server for cluster %s: %v", s.name, err)
+	}
+	return info.GitVersion, nil
+}
+
+// Certificates returns the key material the server was created with
+func (s *Server) Certificates() *Certificates {
+	return s.certs
+}
+
+// AdminToken returns the token of a cluster-admin service account, creating it if needed
+func (s *Server) AdminToken() (string, error) {
+	sa := object{"metadata": map[string]interface{}{"name": adminServiceAccount}}
+	if _, err := s.createObject(serviceAccountResource, adminNamespace, sa); err != nil && !isAlreadyExists(err) {
+		return "", err
+	}
+	return s.serviceAccountToken(adminNamespace, adminServiceAccount)
+}
+
+// RevokeAdminToken deletes the cluster-admin service account and its token secrets, revoking
+// a token that does not exist is not an error
+func (s *Server) RevokeAdminToken() error {
+	if _, err := s.deleteObject(serviceAccountResource, adminNamespace, adminServiceAccount); err != nil && !isNotFound(err) {
+		return err
+	}
+	return nil
+}
+
+func (s *Server) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if req.TLS != nil && len(req.TLS.VerifiedChains) > 0 {
+			next.ServeHTTP(rw, req)
+			return
+		}
+		auth := req.Header.Get("Authorization")
+		if strings.HasPrefix(auth, "Bearer ") && s.validToken(strings.TrimPrefix(auth, "Bearer ")) {
+			next.ServeHTTP(rw, req)
+			return
+		}
+		writeStatus(rw, &storeError{code: http.StatusUnauthorized, reason: "Unauthorized", msg: "Unauthorized"})
+	})
+}
+
+func (s *Server) validToken(token string) bool {
+	for _, secret := range s.store.list(secretResource, "") {
+		data, _ := secret["data"].(map[string]interface{})
+		encoded, _ := data["token"].(string)
+		if encoded == "" {
+			continue
+		}
+		expected, err := base64.StdEncoding.DecodeString(encoded)
+		if err == nil && subtle.ConstantTimeCompare(expected, []byte(token)) == 1 {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Server) serveHTTP(rw http.ResponseWriter, req *http.Request) {
+	path := strings.Trim(req.URL.Path, "/")
+	switch path {
+	case "version":
+		writeJSON(rw, http.StatusOK, versionInfo(s.Version()))
+		return
+	case "api":
+		writeJSON(rw, http.StatusOK, &metav1.APIVersions{
+			TypeMeta: metav1.TypeMeta{Kind: "APIVersions"},
+			Versions: []string{"v1"},
+			ServerAddressByClientCIDRs: []metav1.ServerAddressByClientCIDR{
+				{ClientCIDR: "0.0.0.0/0", ServerAddress: req.Host},
+			},
+		})
+		return
+	case "apis":
+		writeJSON(rw, http.StatusOK, apiGroupList())
+		return
+	}
+
+	parts := strings.Split(path, "/")
+	var group, ver string
+	switch {
+	case len(parts) >= 2 && parts[0] == "api":
+		ver, parts = parts[1], parts[2:]
+	case len(parts) >= 3 && parts[0] == "apis":
+		group, ver, parts = parts[1], parts[2], parts[3:]
+	default:
+		writeStatus(rw, &storeError{code: http.StatusNotFound, reason: "NotFound", msg: "the server could not find the requested resource"})
+		return
+	}
+	if len(parts) == 0 {
+		writeJSON(rw, http.StatusOK, apiResourceList(group, ver))
+		return
+	}
+
+	namespace := ""
+	if len(parts) >= 3 && parts[0] == "namespaces" {
+		namespace, parts = parts[1], parts[2:]
+	}
+	r, ok := lookupResource(group, ver, parts[0])
+	if !ok || len(parts) > 2 {
+		writeStatus(rw, &storeError{code: http.StatusNotFound, reason: "NotFound", msg: "the server could not find the requested resource"})
+		return
+	}
+	name := ""
+	if len(parts) == 2 {
+		name = parts[1]
+	}
+
+	s.serveResource(rw, req, r, namespace, name)
+}
+
+func (s *Server) serveResource(rw http.ResponseWriter, req *http.Request, r resource, namespace, name string) {
+	switch {
+	case req.Method == http.MethodGet && name == "":
+		writeJSON(rw, http.StatusOK, map[string]interface{}{
+			"kind":       r.kind + "List",
+			"apiVersion": r.apiVersion(),
+			"metadata":   map[string]interface{}{},
+			"items":      s.store.list(r, namespace),
+		})
+	case req.Method == http.MethodGet:
+		obj, err := s.store.get(r, namespace, name)
+		writeResult(rw, http.StatusOK, obj, err)
+	case req.Method == http.MethodPost && name == "":
+		obj := object{}
+		if err := json.NewDecoder(req.Body).Decode(&obj); err != nil {
+			writeStatus(rw, &storeError{code: http.StatusBadRequest, reason: "BadRequest", msg: err.Error()})
+			return
+		}
+		obj, err := s.createObject(r, namespace, obj)
+		writeResult(rw, http.StatusCreated, obj, err)
+	case req.Method == http.MethodPut && name != "":
+		obj := object{}
+		if err := json.NewDecoder(req.Body).Decode(&obj); err != nil {
+			writeStatus(rw, &storeError{code: http.StatusBadRequest, reason: "BadRequest", msg: err.Error()})
+			return
+		}
+		obj.metadata()["name"] = name
+		obj, err := s.store.update(r, namespace, obj)
+		writeResult(rw, http.StatusOK, obj, err)
+	case req.Method == http.MethodDelete && name != "":
+		obj, err := s.deleteObject(r, namespace, name)
+		writeResult(rw, http.StatusOK, obj, err)
+	default:
+		writeStatus(rw, &storeError{code: http.StatusMethodNotAllowed, reason: "MethodNotAllowed", msg: fmt.Sprintf("%s is not supported on %s", req.Method, r.name)})
+	}
+}
+
+// createObject stores a new object and runs the controllers a real cluster would run for it
+func (s *Server) createObject(r resource, namespace string, obj object) (object, error) {
+	created, err := s.store.create(r, namespace, obj)
+	if err != nil {
+		return nil, err
+	}
+	if r == serviceAccountResource {
+		return s.createTokenSecret(namespace, created)
+	}
+	return created, nil
+}
+
+// deleteObject removes an object and runs the controllers a real cluster would run for it
+func (s *Server) deleteObject(r resource, namespace, name string) (object, error) {
+	deleted, err := s.store.delete(r, namespace, name)
+	if err != nil {
+		return nil, err
+	}
+	if r == serviceAccountResource {
+		// the token controller deletes the tokens of deleted service accounts
+		secrets, _ := deleted["secrets"].([]interface{})
+		for _, ref := range secrets {
+			secretName, _ := ref.(map[string]interface{})["name"].(string)
+			if _, err := s.store.delete(secretResource, namespace, secretName); err != nil && !isNotFound(err) {
+				return nil, err
+			}
+		}
+	}
+	return deleted, nil
+}
+
+// createTokenSecret mimics the token controller by minting a token secret for a new service account
+func (s *Server) createTokenSecret(namespace string, sa object) (object, error) {
+	token, err := newToken()
+	if err != nil {
+		return nil, err
+	}
+	secret, err := s.store.create(secretResource, namespace, object{
+		"metadata": map[string]interface{}{
+			"generateName": sa.name() + "-token-",
+			"annotations": map[string]interface{}{
+				"kubernetes.io/service-account.name": sa.name(),
+			},
+		},
+		"type": "kubernetes.io/service-account-token",
+		"data": map[string]interface{}{
+			"token":     base64.StdEncoding.EncodeToString([]byte(token)),
+			"ca.crt":    base64.StdEncoding.EncodeToString(s.certs.CACert),
+			"namespace": base64.StdEncoding.EncodeToString([]byte(namespace)),
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	updated := sa.clone()
+	updated["secrets"] = []interface{}{
+		map[string]interface{}{"name": secret.name()},
+	}
+	return s.store.update(serviceAccountResource, namespace, updated)
+}
+
+func (s *Server) serviceAccountToken(namespace, name string) (string, error) {
+	sa, err := s.store.get(serviceAccountResource, namespace, name)
+	if err != nil {
+		return "", err
+	}
+	secrets, _ := sa["secrets"].([]interface{})
+	for _, ref := range secrets {
+		secretName, _ := ref.(map[string]interface{})["name"].(string)
+		secret, err := s.store.get(secretResource, namespace, secretName)
+		if err != nil {
+			continue
+		}
+		data, _ := secret["data"].(map[string]interface{})
+		encoded, _ := data["token"].(string)
+		token, err := base64.StdEncoding.DecodeString(encoded)
+		if err == nil && len(token) > 0 {
+			return string(token), nil
+		}
+	}
+	return "", fmt.Errorf("service account %s/%s has no token", namespace, name)
+}
+
+// clusterAdminRole is the default role every cluster has
+func clusterAdminRole() object {
+	return object{
+		"metadata": map[string]interface{}{"name": "cluster-admin"},
+		"rules": []interface{}{
+			map[string]interface{}{"apiGroups": []interface{}{"*"}, "resources": []interface{}{"*"}, "verbs": []interface{}{"*"}},
+			map[string]interface{}{"nonResourceURLs": []interface{}{"*"}, "verbs": []interface{}{"*"}},
+		},
+	}
+}
+
+func versionInfo(gitVersion string) *version.Info {
+	info := &version.Info{
+		GitVersion: gitVersion,
+		Platform:   "linux/amd64",
+	}
+	parts := strings.SplitN(strings.TrimPrefix(gitVersion, "v"), ".", 3)
+	if len(parts) >= 2 {
+		info.Major, info.Minor = parts[0], parts[1]
+	}
+	return info
+}
+
+func apiGroupList() *metav1.APIGroupList {
+	list := &metav1.APIGroupList{TypeMeta: metav1.TypeMeta{Kind: "APIGroupList", APIVersion: "v1"}}
+	seen := map[string]bool{}
+	for _, r := range resources {
+		if r.group == "" || seen[r.apiVersion()] {
+			continue
+		}
+		seen[r.apiVersion()] = true
+		gv := metav1.GroupVersionForDiscovery{GroupVersion: r.apiVersion(), Version: r.version}
+		list.Groups = append(list.Groups, metav1.APIGroup{
+			Name:             r.group,
+			Versions:         []metav1.GroupVersionForDiscovery{gv},
+			PreferredVersion: gv,
+		})
+	}
+	return list
+}
+
+func apiResourceList(group, ver string) *metav1.APIResourceList {
+	list := &metav1.APIResourceList{TypeMeta: metav1.TypeMeta{Kind: "APIResourceList", APIVersion: "v1"}}
+	for _, r := range resources {
+		if r.group != group || r.version != ver {
+			continue
+		}
+		list.GroupVersion = r.apiVersion()
+		list.APIResources = append(list.APIResources, metav1.APIResource{
+			Name:       r.name,
+			Kind:       r.kind,
+			Namespaced: r.namespaced,
+			Verbs:      metav1.Verbs{"create", "delete", "get", "list", "update"},
+		})
+	}
+	return list
+}
+
+func writeResult(rw http.ResponseWriter, code int, obj object, err error) {
+	if err != nil {
+		writeStatus(rw, err)
+		return
+	}
+	writeJSON(rw, code, obj)
+}
+
+func writeStatus(rw http.ResponseWriter, err error) {
+	storeErr, ok := err.(*storeError)
+	if !ok {
+		storeErr = &storeError{code: http.StatusInternalServerError, reason: "InternalError", msg: err.Error()}
+	}
+	writeJSON(rw, storeErr.code, &metav1.Status{
+		TypeMeta: metav1.TypeMeta{Kind: "Status", APIVersion: "v1"},
+		Status:   metav1.StatusFailure,
+		Message:  storeErr.msg,
+		Reason:   metav1.StatusReason(storeErr.reason),
+		Code:     int32(storeErr.code),
+	})
+}
+
+func writeJSON(rw http.ResponseWriter, code int, obj interface{}) {
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(code)
+	if err := json.NewEncoder(rw).Encode(obj); err != nil {
+		logrus.Debugf("error writing simulated api response: %v", err)
+	}
+}
+
+func isAlreadyExists(err error) bool {
+	storeErr, ok := err.(*storeError)
+	return ok && storeErr.code == http.StatusConflict
+}
+
+func isNotFound(err error) bool {
+	storeErr, ok := err.(*storeError)
+	return ok && storeErr.code == http.StatusNotFound
+}
+
+func newToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("error generating token: %v", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
@@ -0,0 +1,224 @@
+package simulated
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// object is a Kubernetes object in its unstructured JSON form
+type object map[string]interface{}
+
+func (o object) metadata() map[string]interface{} {
+	meta, ok := o["metadata"].(map[string]interface{})
+	if !ok {
+		meta = map[string]interface{}{}
+		o["metadata"] = meta
+	}
+	return meta
+}
+
+// clone returns a deep copy so stored objects are never mutated in place
+func (o object) clone() object {
+	data, err := json.Marshal(o)
+	if err != nil {
+		return object{}
+	}
+	result := object{}
+	json.Unmarshal(data, &result)
+	return result
+}
+
+func (o object) name() string {
+	name, _ := o.metadata()["name"].(string)
+	return name
+}
+
+// resource describes a REST resource served by the simulated API server
+type resource struct {
+	group      string
+	version    string
+	name       string
+	kind       string
+	namespaced bool
+}
+
+func (r resource) apiVersion() string {
+	if r.group == "" {
+		return r.version
+	}
+	return r.group + "/" + r.version
+}
+
+var (
+	namespaceResource      = resource{version: "v1", name: "namespaces", kind: "Namespace"}
+	nodeResource           = resource{version: "v1", name: "nodes", kind: "Node"}
+	serviceAccountResource = resource{version: "v1", name: "serviceaccounts", kind: "ServiceAccount", namespaced: true}
+	secretResource         = resource{version: "v1", name: "secrets", kind: "Secret", namespaced: true}
+
+	clusterRoleResource        = resource{group: "rbac.authorization.k8s.io", version: "v1", name: "clusterroles", kind: "ClusterRole"}
+	clusterRoleBindingResource = resource{group: "rbac.authorization.k8s.io", version: "v1", name: "clusterrolebindings", kind: "ClusterRoleBinding"}
+
+	resources = []resource{
+		namespaceResource,
+		nodeResource,
+		serviceAccountResource,
+		secretResource,
+		clusterRoleResource,
+		clusterRoleBindingResource,
+	}
+)
+
+func lookupResource(group, version, name string) (resource, bool) {
+	for _, r := range resources {
+		if r.group == group && r.version == version && r.name == name {
+			return r, true
+		}
+	}
+	return resource{}, false
+}
+
+type storeError struct {
+	code   int
+	reason string
+	msg    string
+}
+
+func (e *storeError) Error() string {
+	return e.msg
+}
+
+func notFound(r resource, name string) error {
+	return &storeError{code: 404, reason: "NotFound", msg: fmt.Sprintf("%s %q not found", r.name, name)}
+}
+
+func alreadyExists(r resource, name string) error {
+	return &storeError{code: 409, reason: "AlreadyExists", msg: fmt.Sprintf("%s %q already exists", r.name, name)}
+}
+
+// store is an in-memory object store keyed by resource, namespace and name
+type store struct {
+	sync.Mutex
+	revision int64
+	objects  map[string]object
+}
+
+func newStore() *store {
+	return &store{
+		objects: map[string]object{},
+	}
+}
+
+func objectKey(r resource, namespace, name string) string {
+	return r.apiVersion() + "/" + r.name + "/" + namespace + "/" + name
+}
+
+func (s *store) get(r resource, namespace, name string) (object, error) {
+	s.Lock()
+	defer s.Unlock()
+	obj, ok := s.objects[objectKey(r, namespace, name)]
+	if !ok {
+		return nil, notFound(r, name)
+	}
+	return obj, nil
+}
+
+func (s *store) list(r resource, namespace string) []object {
+	s.Lock()
+	defer s.Unlock()
+	prefix := objectKey(r, namespace, "")
+	if !r.namespaced || namespace == "" {
+		prefix = r.apiVersion() + "/" + r.name + "/"
+	}
+	var keys []string
+	for key := range s.objects {
+		if len(key) >= len(prefix) && key[:len(prefix)] == prefix {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	result := make([]object, 0, len(keys))
+	for _, key := range keys {
+		result = append(result, s.objects[key])
+	}
+	return result
+}
+
+func (s *store) create(r resource, namespace string, obj object) (object, error) {
+	s.Lock()
+	defer s.Unlock()
+	name := obj.name()
+	if name == "" {
+		if prefix, ok := obj.metadata()["generateName"].(string); ok && prefix != "" {
+			name = prefix + randomSuffix()
+			obj.metadata()["name"] = name
+		}
+	}
+	if name == "" {
+		return nil, &storeError{code: 422, reason: "Invalid", msg: "metadata.name is required"}
+	}
+	key := objectKey(r, namespace, name)
+	if _, ok := s.objects[key]; ok {
+		return nil, alreadyExists(r, name)
+	}
+	obj["kind"] = r.kind
+	obj["apiVersion"] = r.apiVersion()
+	meta := obj.metadata()
+	if r.namespaced {
+		meta["namespace"] = namespace
+	}
+	meta["uid"] = randomSuffix() + randomSuffix()
+	meta["creationTimestamp"] = time.Now().UTC().Format(time.RFC3339)
+	s.revision++
+	meta["resourceVersion"] = strconv.FormatInt(s.revision, 10)
+	s.objects[key] = obj
+	return obj, nil
+}
+
+func (s *store) update(r resource, namespace string, obj object) (object, error) {
+	s.Lock()
+	defer s.Unlock()
+	key := objectKey(r, namespace, obj.name())
+	existing, ok := s.objects[key]
+	if !ok {
+		return nil, notFound(r, obj.name())
+	}
+	obj["kind"] = r.kind
+	obj["apiVersion"] = r.apiVersion()
+	meta := obj.metadata()
+	for _, field := range []string{"namespace", "uid", "creationTimestamp"} {
+		if value, ok := existing.metadata()[field]; ok {
+			meta[field] = value
+		}
+	}
+	s.revision++
+	meta["resourceVersion"] = strconv.FormatInt(s.revision, 10)
+	s.objects[key] = obj
+	return obj, nil
+}
+
+func (s *store) delete(r resource, namespace, name string) (object, error) {
+	s.Lock()
+	defer s.Unlock()
+	key := objectKey(r, namespace, name)
+	obj, ok := s.objects[key]
+	if !ok {
+		return nil, notFound(r, name)
+	}
+	delete(s.objects, key)
+	return obj, nil
+}
+
+const suffixAlphabet = "bcdfghjklmnpqrstvwxz2456789"
+
+func randomSuffix() string {
+	b := make([]byte, 5)
+	for i := range b {
+		b[i] = suffixAlphabet[rand.Intn(len(suffixAlphabet))]
+	}
+	return string(b)
+}
@@ -0,0 +1,281 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/rancher/example-kontainer-engine-driver/simulated"
+	"github.com/rancher/example-kontainer-engine-driver/wait"
+	"github.com/rancher/kontainer-engine/types"
+)
+
+const simulatedBackendName = "simulated"
+
+// simulatedBackend runs a fake Kubernetes API server inside the driver process for every cluster
+type simulatedBackend struct {
+	sync.Mutex
+	servers map[string]*simulated.Server
+	// backoff controls waiting for the api servers
+	backoff wait.Backoff
+}
+
+func newSimulatedBackend() *simulatedBackend {
+	backoff := wait.DefaultBackoff
+	backoff.Timeout = 5 * time.Minute
+	return &simulatedBackend{
+		servers: map[string]*simulated.Server{},
+		backoff: backoff,
+	}
+}
+
+func (b *simulatedBackend) Name() string {
+	return simulatedBackendName
+}
+
+func (b *simulatedBackend) CreateSteps() []createStep {
+	return []createStep{
+		// the api servers live in the driver process, a restarted driver has to start them again
+		{name: "api-server", run: b.startServer, undo: b.stopServer, done: b.hasServer},
+		{name: "api-server-ready", run: b.waitForServer},
+		{name: "nodes", run: b.registerNodes, undo: b.unregisterNodes},
+		{name: "admin-token", run: b.createAdminToken, undo: b.revokeAdminToken},
+	}
+}
+
+// CheckHealth makes sure the backend is not stuck holding its lock and that it can still open
+// ports for new api servers
+func (b *simulatedBackend) CheckHealth(ctx context.Context) error {
+	unlocked := make(chan struct{})
+	go func() {
+		b.Lock()
+		b.Unlock()
+		close(unlocked)
+	}()
+	select {
+	case <-unlocked:
+	case <-ctx.Done():
+		return fmt.Errorf("the simulated backend is stuck")
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return fmt.Errorf("cannot open a port for an api server: %v", err)
+	}
+	return listener.Close()
+}
+
+// startServer starts the api server of the cluster, reusing the one an earlier attempt started
+func (b *simulatedBackend) startServer(ctx context.Context, state *state, info *types.ClusterInfo) error {
+	b.Lock()
+	defer b.Unlock()
+
+	name := state.Name
+	server, ok := b.servers[name]
+	if !ok {
+		certs, err := simulated.GenerateCertificates(name)
+		if err != nil {
+			return err
+		}
+		server = simulated.NewServer(name, state.KubernetesVersion, certs)
+	}
+
+	if err := server.Start("127.0.0.1:0"); err != nil {
+		return fmt.Errorf("error starting simulated api server: %v", err)
+	}
+	b.servers[name] = server
+	clusterLog(ctx, name).Infof("simulated api server for cluster %s listening on %s", name, server.Endpoint())
+
+	state.Endpoint = server.Endpoint()
+	certs := server.Certificates()
+	info.Endpoint = server.Endpoint()
+	info.RootCaCertificate = base64.StdEncoding.EncodeToString(certs.CACert)
+	info.ClientCertificate = base64.StdEncoding.EncodeToString(certs.ClientCert)
+	info.ClientKey = base64.StdEncoding.EncodeToString(certs.ClientKey)
+	return nil
+}
+
+// hasServer reports whether the api server of the cluster was started by this process
+func (b *simulatedBackend) hasServer(state *state) bool {
+	b.Lock()
+	defer b.Unlock()
+	_, ok := b.servers[state.Name]
+	return ok
+}
+
+// stopServer stops the api server of the cluster and forgets how to reach it
+func (b *simulatedBackend) stopServer(ctx context.Context, state *state, info *types.ClusterInfo) error {
+	if err := b.Remove(ctx, state); err != nil {
+		return err
+	}
+	state.Endpoint = ""
+	info.Endpoint = ""
+	info.RootCaCertificate = ""
+	info.ClientCertificate = ""
+	info.ClientKey = ""
+	return nil
+}
+
+// waitForServer waits until the api server of the cluster answers requests
+func (b *simulatedBackend) waitForServer(ctx context.Context, state *state, info *types.ClusterInfo) error {
+	server, err := b.server(state)
+	if err != nil {
+		return err
+	}
+	return waitFor(ctx, b.backoff, state.Name, fmt.Sprintf("the api server of cluster %s", state.Name), func(ctx context.Context) (bool, error) {
+		_, err := server.ServingVersion(ctx)
+		return err == nil, err
+	})
+}
+
+// createAdminToken creates the admin service account, or looks up its token if it already exists
+func (b *simulatedBackend) createAdminToken(ctx context.Context, state *state, info *types.ClusterInfo) error {
+	server, err := b.server(state)
+	if err != nil {
+		return err
+	}
+	token, err := server.AdminToken()
+	if err != nil {
+		return fmt.Errorf("error generating admin token: %v", err)
+	}
+	info.ServiceAccountToken = token
+	return nil
+}
+
+func (b *simulatedBackend) GetVersion(ctx context.Context, state *state) (string, error) {
+	server, err := b.server(state)
+	if err != nil {
+		return "", err
+	}
+	return server.Version(), nil
+}
+
+func (b *simulatedBackend) SetVersion(ctx context.Context, state *state, version string) error {
+	server, err := b.server(state)
+	if err != nil {
+		return err
+	}
+	clusterLog(ctx, state.Name).Infof("upgrading simulated api server for cluster %s from %s to %s", state.Name, server.Version(), version)
+	if err := server.SetVersion(version); err != nil {
+		return err
+	}
+	return waitFor(ctx, b.backoff, state.Name, fmt.Sprintf("cluster %s to serve kubernetes %s", state.Name, version), func(ctx context.Context) (bool, error) {
+		serving, err := server.ServingVersion(ctx)
+		return serving == version, err
+	})
+}
+
+func (b *simulatedBackend) GetClusterSize(ctx context.Context, state *state) (int64, error) {
+	server, err := b.server(state)
+	if err != nil {
+		return 0, err
+	}
+	return int64(len(server.Nodes())), nil
+}
+
+// registerNodes registers the nodes of every pool, replacing whatever nodes were registered before
+func (b *simulatedBackend) registerNodes(ctx context.Context, state *state, info *types.ClusterInfo) error {
+	if err := b.SetNodePools(ctx, state); err != nil {
+		return fmt.Errorf("error registering nodes: %v", err)
+	}
+	return nil
+}
+
+func (b *simulatedBackend) GetNodePoolSizes(ctx context.Context, state *state) (map[string]int64, error) {
+	server, err := b.server(state)
+	if err != nil {
+		return nil, err
+	}
+	return server.CountNodes(nodePoolLabel), nil
+}
+
+// unregisterNodes removes every node, there is nothing to remove when the api server is gone
+func (b *simulatedBackend) unregisterNodes(ctx context.Context, state *state, info *types.ClusterInfo) error {
+	server, err := b.server(state)
+	if err != nil {
+		return nil
+	}
+	return server.SetNodes(nil)
+}
+
+// revokeAdminToken deletes the admin service account and forgets its token
+func (b *simulatedBackend) revokeAdminToken(ctx context.Context, state *state, info *types.ClusterInfo) error {
+	if err := b.RevokeAdminToken(ctx, state); err != nil {
+		return err
+	}
+	info.ServiceAccountToken = ""
+	return nil
+}
+
+// RevokeAdminToken deletes the admin service account, there is nothing to delete when the api
+// server is gone
+func (b *simulatedBackend) RevokeAdminToken(ctx context.Context, state *state) error {
+	server, err := b.server(state)
+	if err != nil {
+		return nil
+	}
+	return server.RevokeAdminToken()
+}
+
+func (b *simulatedBackend) SetNodePools(ctx context.Context, state *state) error {
+	server, err := b.server(state)
+	if err != nil {
+		return err
+	}
+	return server.SetNodes(simulatedNodes(state.NodePools))
+}
+
+func (b *simulatedBackend) Remove(ctx context.Context, state *state) error {
+	b.Lock()
+	defer b.Unlock()
+
+	server, ok := b.servers[state.Name]
+	if !ok {
+		clusterLog(ctx, state.Name).Warnf("no simulated api server found for cluster %s", state.Name)
+		return nil
+	}
+	delete(b.servers, state.Name)
+	return server.Stop()
+}
+
+func (b *simulatedBackend) server(state *state) (*simulated.Server, error) {
+	b.Lock()
+	defer b.Unlock()
+
+	server, ok := b.servers[state.Name]
+	if !ok {
+		return nil, fmt.Errorf("no simulated api server is running for cluster %s", state.Name)
+	}
+	return server, nil
+}
+
+func simulatedNodes(pools []nodePool) []simulated.Node {
+	var nodes []simulated.Node
+	for _, pool := range pools {
+		var taints []simulated.Taint
+		for _, taint := range pool.Taints {
+			key, value, effect, err := parseTaint(taint)
+			if err != nil {
+				continue
+			}
+			taints = append(taints, simulated.Taint{Key: key, Value: value, Effect: effect})
+		}
+		labels := map[string]string{
+			nodePoolLabel: pool.Name,
+		}
+		for k, v := range pool.Labels {
+			labels[k] = v
+		}
+		for i := int64(0); i < pool.Count; i++ {
+			nodes = append(nodes, simulated.Node{
+				Name:   fmt.Sprintf("%s-node-%d", pool.Name, i),
+				Labels: labels,
+				Taints: taints,
+			})
+		}
+	}
+	return nodes
+}
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/rancher/kontainer-engine/types"
+)
+
+const (
+	stateKey = "state"
+
+	// currentStateVersion is the schema version written by this driver, bump it and
+	// register a migration in stateMigrations whenever the state struct changes shape
+	currentStateVersion = 8
+)
+
+// state is everything MyDriver needs to remember about a cluster between calls
+type state struct {
+	// SchemaVersion of the serialized state
+	SchemaVersion int
+	// The internal name of the cluster in Rancher
+	Name string
+	// The name of the cluster that should be displayed to the user
+	DisplayName string
+	// The backend the cluster was provisioned with
+	Backend string
+	// The endpoint of the cluster API server
+	Endpoint string
+	// The kubernetes version the cluster runs
+	KubernetesVersion string
+	// The version changes recorded in the state, oldest first. Several SetVersion calls between
+	// two stored states show up as a single change.
+	Upgrades []versionUpgrade
+	// The node pools of the cluster
+	NodePools []nodePool
+	// The rule for picking the node pool that absorbs a cluster size change
+	ResizePolicy string
+	// The node pool that absorbs cluster size changes, overrides ResizePolicy
+	ResizePool string
+	// The IP address range of the pods
+	ClusterCIDR string
+	// The IP address range of the services
+	ServiceCIDR string
+	// The create steps that completed, in order
+	Checkpoints []string
+	// Whether a failed create undoes the steps it completed
+	RollbackOnFailure bool
+	// How long each kind of operation may take
+	Timeouts operationTimeouts
+	// The service account Rancher manages the cluster with
+	Bootstrap bootstrapIdentity
+}
+
+// versionUpgrade records a kubernetes version change and when the state recorded it
+type versionUpgrade struct {
+	From string
+	To   string
+	Time time.Time
+}
+
+// stateMigration upgrades raw state from one schema version to the next
+type stateMigration func(info *types.ClusterInfo, raw map[string]interface{}) error
+
+// stateMigrations maps a schema version to the migration that upgrades it to the next version
+var stateMigrations = map[int]stateMigration{
+	0: migrateStateV0,
+	1: migrateStateV1,
+	2: migrateStateV2,
+	3: migrateStateV3,
+	4: migrateStateV4,
+	5: migrateStateV5,
+	6: migrateStateV6,
+	7: migrateStateV7,
+}
+
+// migrateStateV0 handles clusters created before state was persisted, those only recorded their name
+func migrateStateV0(info *types.ClusterInfo, raw map[string]interface{}) error {
+	if _, ok := raw["Name"]; !ok {
+		raw["Name"] = info.Metadata["name"]
+	}
+	if _, ok := raw["Backend"]; !ok {
+		raw["Backend"] = simulatedBackendName
+	}
+	if _, ok := raw["Endpoint"]; !ok {
+		raw["Endpoint"] = info.Endpoint
+	}
+	return nil
+}
+
+// migrateStateV1 records the kubernetes version, clusters created before versions were tracked
+// report it in the cluster info or run the simulated default
+func migrateStateV1(info *types.ClusterInfo, raw map[string]interface{}) error {
+	if _, ok := raw["KubernetesVersion"]; ok {
+		return nil
+	}
+	raw["KubernetesVersion"] = defaultKubernetesVersion
+	if info.Version != "" {
+		raw["KubernetesVersion"] = info.Version
+	}
+	return nil
+}
+
+// migrateStateV2 moves clusters created before node pools existed into a single default pool
+func migrateStateV2(info *types.ClusterInfo, raw map[string]interface{}) error {
+	if _, ok := raw["NodePools"]; ok {
+		return nil
+	}
+	raw["NodePools"] = []nodePool{
+		{
+			Name:  defaultNodePoolName,
+			Count: info.NodeCount,
+		},
+	}
+	raw["ResizePolicy"] = resizeLast
+	return nil
+}
+
+// migrateStateV3 records the network ranges, clusters created before they were configurable
+// use the defaults
+func migrateStateV3(info *types.ClusterInfo, raw map[string]interface{}) error {
+	if _, ok := raw["ClusterCIDR"]; !ok {
+		raw["ClusterCIDR"] = defaultClusterCIDR
+	}
+	if _, ok := raw["ServiceCIDR"]; !ok {
+		raw["ServiceCIDR"] = defaultServiceCIDR
+	}
+	return nil
+}
+
+// migrateStateV4 marks clusters created before create steps were checkpointed as created, a
+// failed create never stored any state back then
+func migrateStateV4(info *types.ClusterInfo, raw map[string]interface{}) error {
+	if _, ok := raw["Checkpoints"]; !ok {
+		raw["Checkpoints"] = []string{createdCheckpoint}
+	}
+	return nil
+}
+
+// migrateStateV5 keeps the create behavior of clusters created before rollback was an option
+func migrateStateV5(info *types.ClusterInfo, raw map[string]interface{}) error {
+	if _, ok := raw["RollbackOnFailure"]; !ok {
+		raw["RollbackOnFailure"] = false
+	}
+	return nil
+}
+
+// migrateStateV6 gives clusters created before operations had timeouts the default timeouts
+func migrateStateV6(info *types.ClusterInfo, raw map[string]interface{}) error {
+	if _, ok := raw["Timeouts"]; !ok {
+		raw["Timeouts"] = operationTimeouts{
+			Create: defaultCreateTimeout,
+			Update: defaultUpdateTimeout,
+			Remove: defaultRemoveTimeout,
+		}
+	}
+	return nil
+}
+
+// migrateStateV7 records the bootstrap identity clusters got before it was configurable
+func migrateStateV7(info *types.ClusterInfo, raw map[string]interface{}) error {
+	if _, ok := raw["Bootstrap"]; !ok {
+		raw["Bootstrap"] = bootstrapIdentity{
+			ServiceAccount: defaultBootstrapServiceAccount,
+			Namespace:      defaultBootstrapNamespace,
+			ClusterRole:    defaultBootstrapClusterRole,
+			Binding:        defaultBootstrapServiceAccount,
+		}
+	}
+	return nil
+}
+
+// completed reports whether a create step or checkpoint has been reached
+func (s state) completed(checkpoint string) bool {
+	for _, c := range s.Checkpoints {
+		if c == checkpoint {
+			return true
+		}
+	}
+	return false
+}
+
+func storeState(info *types.ClusterInfo, state state) error {
+	state.SchemaVersion = currentStateVersion
+	bytes, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	if info.Metadata == nil {
+		info.Metadata = map[string]string{}
+	}
+	info.Metadata[stateKey] = string(bytes)
+	return nil
+}
+
+func getState(info *types.ClusterInfo) (state, error) {
+	state := state{}
+	if info == nil {
+		return state, fmt.Errorf("cluster info is required")
+	}
+
+	raw := map[string]interface{}{}
+	if data := info.Metadata[stateKey]; data != "" {
+		if err := json.Unmarshal([]byte(data), &raw); err != nil {
+			return state, fmt.Errorf("error unmarshalling state: %v", err)
+		}
+	}
+
+	version := 0
+	if v, ok := raw["SchemaVersion"].(float64); ok {
+		version = int(v)
+	}
+	if version > currentStateVersion {
+		return state, fmt.Errorf("state schema version %d is newer than the supported version %d", version, currentStateVersion)
+	}
+
+	for ; version < currentStateVersion; version++ {
+		migrate, ok := stateMigrations[version]
+		if !ok {
+			return state, fmt.Errorf("no migration registered for state schema version %d", version)
+		}
+		if err := migrate(info, raw); err != nil {
+			return state, fmt.Errorf("error migrating state from schema version %d: %v", version, err)
+		}
+		raw["SchemaVersion"] = version + 1
+	}
+
+	bytes, err := json.Marshal(raw)
+	if err != nil {
+		return state, err
+	}
+	err = json.Unmarshal(bytes, &state)
+	return state, err
+}
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rancher/kontainer-engine/types"
+)
+
+// defaultTimeouts are the timeouts the migrations give clusters that predate them
+var defaultTimeouts = operationTimeouts{
+	Create: defaultCreateTimeout,
+	Update: defaultUpdateTimeout,
+	Remove: defaultRemoveTimeout,
+}
+
+// legacyBootstrap is the identity the migrations record for clusters that predate it
+var legacyBootstrap = bootstrapIdentity{
+	ServiceAccount: defaultBootstrapServiceAccount,
+	Namespace:      defaultBootstrapNamespace,
+	ClusterRole:    defaultBootstrapClusterRole,
+	Binding:        defaultBootstrapServiceAccount,
+}
+
+func TestGetState(t *testing.T) {
+	tests := []struct {
+		name    string
+		info    *types.ClusterInfo
+		want    state
+		wantErr string
+	}{
+		{
+			name: "from version 0",
+			info: &types.ClusterInfo{
+				Endpoint:  "https://127.0.0.1:6443",
+				Version:   "v1.9.7",
+				NodeCount: 3,
+				Metadata:  map[string]string{"name": "c1"},
+			},
+			want: state{
+				SchemaVersion:     currentStateVersion,
+				Name:              "c1",
+				Backend:           simulatedBackendName,
+				Endpoint:          "https://127.0.0.1:6443",
+				KubernetesVersion: "v1.9.7",
+				NodePools:         []nodePool{{Name: defaultNodePoolName, Count: 3}},
+				ResizePolicy:      resizeLast,
+				ClusterCIDR:       defaultClusterCIDR,
+				ServiceCIDR:       defaultServiceCIDR,
+				Checkpoints:       []string{createdCheckpoint},
+				Timeouts:          defaultTimeouts,
+				Bootstrap:         legacyBootstrap,
+			},
+		},
+		{
+			name: "from version 1",
+			info: &types.ClusterInfo{
+				NodeCount: 2,
+				Metadata:  map[string]string{stateKey: `{"SchemaVersion":1,"Name":"c1","Backend":"simulated","Endpoint":"https://127.0.0.1:6443"}`},
+			},
+			want: state{
+				SchemaVersion:     currentStateVersion,
+				Name:              "c1",
+				Backend:           simulatedBackendName,
+				Endpoint:          "https://127.0.0.1:6443",
+				KubernetesVersion: defaultKubernetesVersion,
+				NodePools:         []nodePool{{Name: defaultNodePoolName, Count: 2}},
+				ResizePolicy:      resizeLast,
+				ClusterCIDR:       defaultClusterCIDR,
+				ServiceCIDR:       defaultServiceCIDR,
+				Checkpoints:       []string{createdCheckpoint},
+				Timeouts:          defaultTimeouts,
+				Bootstrap:         legacyBootstrap,
+			},
+		},
+		{
+			name: "from version 2",
+			info: &types.ClusterInfo{
+				NodeCount: 2,
+				Metadata:  map[string]string{stateKey: `{"SchemaVersion":2,"Name":"c1","Backend":"simulated","KubernetesVersion":"v1.11.1"}`},
+			},
+			want: state{
+				SchemaVersion:     currentStateVersion,
+				Name:              "c1",
+				Backend:           simulatedBackendName,
+				KubernetesVersion: "v1.11.1",
+				NodePools:         []nodePool{{Name: defaultNodePoolName, Count: 2}},
+				ResizePolicy:      resizeLast,
+				ClusterCIDR:       defaultClusterCIDR,
+				ServiceCIDR:       defaultServiceCIDR,
+				Checkpoints:       []string{createdCheckpoint},
+				Timeouts:          defaultTimeouts,
+				Bootstrap:         legacyBootstrap,
+			},
+		},
+		{
+			name: "from version 3",
+			info: &types.ClusterInfo{
+				Metadata: map[string]string{stateKey: `{"SchemaVersion":3,"Name":"c1","NodePools":[{"Name":"a","Count":1}]}`},
+			},
+			want: state{
+				SchemaVersion: currentStateVersion,
+				Name:          "c1",
+				NodePools:     []nodePool{{Name: "a", Count: 1}},
+				ClusterCIDR:   defaultClusterCIDR,
+				ServiceCIDR:   defaultServiceCIDR,
+				Checkpoints:   []string{createdCheckpoint},
+				Timeouts:      defaultTimeouts,
+				Bootstrap:     legacyBootstrap,
+			},
+		},
+		{
+			name: "from version 4",
+			info: &types.ClusterInfo{
+				Metadata: map[string]string{stateKey: `{"SchemaVersion":4,"Name":"c1"}`},
+			},
+			want: state{
+				SchemaVersion: currentStateVersion,
+				Name:          "c1",
+				Checkpoints:   []string{createdCheckpoint},
+				Timeouts:      defaultTimeouts,
+				Bootstrap:     legacyBootstrap,
+			},
+		},
+		{
+			name: "from version 5",
+			info: &types.ClusterInfo{
+				Metadata: map[string]string{stateKey: `{"SchemaVersion":5,"Name":"c1","Checkpoints":["api-server"]}`},
+			},
+			want: state{
+				SchemaVersion: currentStateVersion,
+				Name:          "c1",
+				Checkpoints:   []string{"api-server"},
+				Timeouts:      defaultTimeouts,
+				Bootstrap:     legacyBootstrap,
+			},
+		},
+		{
+			name: "from version 6",
+			info: &types.ClusterInfo{
+				Metadata: map[string]string{stateKey: `{"SchemaVersion":6,"Name":"c1","RollbackOnFailure":true}`},
+			},
+			want: state{
+				SchemaVersion:     currentStateVersion,
+				Name:              "c1",
+				RollbackOnFailure: true,
+				Timeouts:          defaultTimeouts,
+				Bootstrap:         legacyBootstrap,
+			},
+		},
+		{
+			name: "from version 7",
+			info: &types.ClusterInfo{
+				Metadata: map[string]string{stateKey: `{"SchemaVersion":7,"Name":"c1","Timeouts":{"Create":60000000000}}`},
+			},
+			want: state{
+				SchemaVersion: currentStateVersion,
+				Name:          "c1",
+				Timeouts:      operationTimeouts{Create: time.Minute},
+				Bootstrap:     legacyBootstrap,
+			},
+		},
+		{
+			name: "migrations keep what is already recorded",
+			info: &types.ClusterInfo{
+				Endpoint: "https://127.0.0.1:6443",
+				Metadata: map[string]string{
+					"name": "c1",
+					stateKey: `{"Name":"c2","Backend":"other","Endpoint":"https://10.0.0.1","KubernetesVersion":"v1.8.11",` +
+						`"NodePools":[{"Name":"a","Count":1}],"ResizePolicy":"first",` +
+						`"ClusterCIDR":"10.100.0.0/16","ServiceCIDR":"10.200.0.0/16","Checkpoints":["api-server"],"RollbackOnFailure":true,` +
+						`"Timeouts":{"Create":60000000000,"Update":0,"Remove":1000000000},` +
+						`"Bootstrap":{"ServiceAccount":"rancher","Namespace":"kube-system","Rules":["verb=get,resource=nodes"]}}`,
+				},
+			},
+			want: state{
+				SchemaVersion:     currentStateVersion,
+				Name:              "c2",
+				Backend:           "other",
+				Endpoint:          "https://10.0.0.1",
+				KubernetesVersion: "v1.8.11",
+				NodePools:         []nodePool{{Name: "a", Count: 1}},
+				ResizePolicy:      resizeFirst,
+				ClusterCIDR:       "10.100.0.0/16",
+				ServiceCIDR:       "10.200.0.0/16",
+				Checkpoints:       []string{"api-server"},
+				Timeouts:          operationTimeouts{Create: time.Minute, Remove: time.Second},
+				Bootstrap: bootstrapIdentity{
+					ServiceAccount: "rancher",
+					Namespace:      "kube-system",
+					Rules:          []string{"verb=get,resource=nodes"},
+				},
+				RollbackOnFailure: true,
+			},
+		},
+		{
+			name: "newer version",
+			info: &types.ClusterInfo{
+				Metadata: map[string]string{stateKey: `{"SchemaVersion":1000}`},
+			},
+			wantErr: "state schema version 1000 is newer than the supported version",
+		},
+		{
+			name: "invalid state",
+			info: &types.ClusterInfo{
+				Metadata: map[string]string{stateKey: `{`},
+			},
+			wantErr: "error unmarshalling state",
+		},
+		{
+			name:    "no cluster info",
+			wantErr: "cluster info is required",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := getState(test.info)
+			if test.wantErr != "" {
+				if err == nil || !strings.HasPrefix(err.Error(), test.wantErr) {
+					t.Fatalf("error is %v, want one starting with %q", err, test.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("state is\n%+v\nwant\n%+v", got, test.want)
+			}
+
+			// the migrated state is stored as the current version and reads back unchanged
+			info := &types.ClusterInfo{}
+			if err := storeState(info, got); err != nil {
+				t.Fatal(err)
+			}
+			again, err := getState(info)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(again, got) {
+				t.Errorf("stored state reads back as\n%+v\nwant\n%+v", again, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/rancher/example-kontainer-engine-driver/wait"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// operationTimeouts bound how long the operations on a cluster may take, 0 means no limit
+type operationTimeouts struct {
+	// Create bounds creating the cluster, including every create step
+	Create time.Duration
+	// Update bounds updates, version upgrades and resizes
+	Update time.Duration
+	// Remove bounds removing the cluster
+	Remove time.Duration
+}
+
+// withTimeout derives the context of an operation, the gRPC deadline of the caller still applies
+func withTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, timeout)
+}
+
+// contextError returns the gRPC status of an operation whose context is done, or nil while the
+// operation may go on
+func contextError(ctx context.Context, operation string) error {
+	switch ctx.Err() {
+	case context.Canceled:
+		return status.Errorf(codes.Canceled, "%s was canceled", operation)
+	case context.DeadlineExceeded:
+		return status.Errorf(codes.DeadlineExceeded, "%s did not finish in time", operation)
+	}
+	return nil
+}
+
+// operationError prefers the status of a done context over err, a backend call that failed
+// because it was interrupted should report the interruption
+func operationError(ctx context.Context, operation string, err error) error {
+	if ctxErr := contextError(ctx, operation); ctxErr != nil {
+		return ctxErr
+	}
+	return err
+}
+
+// waitFor polls condition with backoff, reporting progress to the log of the cluster. Errors of
+// the condition are retried, the last one is returned if waiting fails.
+func waitFor(ctx context.Context, backoff wait.Backoff, cluster, description string, condition wait.ConditionFunc) error {
+	var lastErr error
+	err := wait.Poller{
+		Description: description,
+		Backoff:     backoff,
+		Logger:      clusterLog(ctx, cluster),
+	}.Until(ctx, func(ctx context.Context) (bool, error) {
+		done, err := condition(ctx)
+		lastErr = err
+		return done, nil
+	})
+	if err != nil && lastErr != nil && ctx.Err() == nil {
+		return fmt.Errorf("%v: %v", err, lastErr)
+	}
+	return err
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"time"
+)
+
+// ephemeralValidity is how long the certificates of an ephemeral CA are valid, they only live
+// as long as the process
+const ephemeralValidity = 10 * 365 * 24 * time.Hour
+
+// tlsOptions are the transport security settings of the serve command
+type tlsOptions struct {
+	CertFile     string
+	KeyFile      string
+	ClientCAFile string
+	// Ephemeral generates a CA at startup and hands a client certificate to the parent
+	// process through the handshake
+	Ephemeral bool
+}
+
+func (o tlsOptions) validate() error {
+	files := o.CertFile != "" || o.KeyFile != "" || o.ClientCAFile != ""
+	switch {
+	case o.Ephemeral && files:
+		return fmt.Errorf("--tls-ephemeral cannot be combined with --tls-cert, --tls-key or --tls-client-ca")
+	case files && (o.CertFile == "" || o.KeyFile == "" || o.ClientCAFile == ""):
+		return fmt.Errorf("--tls-cert, --tls-key and --tls-client-ca must be given together")
+	}
+	return nil
+}
+
+// serverTLS returns the TLS config of the server, nil to serve without TLS, along with the
+// credentials to hand out in the handshake. host is the address the server listens on.
+func serverTLS(o tlsOptions, host string) (*tls.Config, []byte, error) {
+	switch {
+	case o.Ephemeral:
+		return ephemeralTLS(host)
+	case o.CertFile != "":
+		config, err := loadServerTLS(o.CertFile, o.KeyFile, o.ClientCAFile)
+		return config, nil, err
+	}
+	return nil, nil, nil
+}
+
+// loadServerTLS requires clients to present a certificate signed by the CA in caFile
+func loadServerTLS(certFile, keyFile, caFile string) (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("error loading serving certificate: %v", err)
+	}
+	caPEM, err := ioutil.ReadFile(caFile)
+	if err != nil {
+		return nil, fmt.Errorf("error reading client ca: %v", err)
+	}
+	clientCAs := x509.NewCertPool()
+	if !clientCAs.AppendCertsFromPEM(caPEM) {
+		return nil, fmt.Errorf("no certificates found in %s", caFile)
+	}
+	return mutualTLS(cert, clientCAs), nil
+}
+
+// ephemeralTLS generates a CA, a serving certificate for host and the loopback interface and a
+// client certificate. The CA key is dropped, nothing else can ever be signed by it, and the
+// credentials returned are the CA certificate, the client certificate and its key as PEM.
+func ephemeralTLS(host string) (*tls.Config, []byte, error) {
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error generating ca key: %v", err)
+	}
+	caTemplate, err := certificateTemplate("mydriver-ephemeral-ca")
+	if err != nil {
+		return nil, nil, err
+	}
+	caTemplate.IsCA = true
+	caTemplate.BasicConstraintsValid = true
+	caTemplate.KeyUsage = x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature
+	caDER, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error creating ca certificate: %v", err)
+	}
+	ca, err := x509.ParseCertificate(caDER)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	serverTemplate, err := certificateTemplate("mydriver")
+	if err != nil {
+		return nil, nil, err
+	}
+	serverTemplate.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}
+	serverTemplate.IPAddresses = []net.IP{net.IPv4(127, 0, 0, 1), net.IPv6loopback}
+	serverTemplate.DNSNames = []string{"localhost"}
+	if ip := net.ParseIP(host); ip != nil && !ip.IsUnspecified() {
+		serverTemplate.IPAddresses = append(serverTemplate.IPAddresses, ip)
+	} else if ip == nil && host != "" {
+		serverTemplate.DNSNames = append(serverTemplate.DNSNames, host)
+	}
+	serverCert, serverKey, err := signCertificate(serverTemplate, ca, caKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	serving, err := tls.X509KeyPair(serverCert, serverKey)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	clientTemplate, err := certificateTemplate("rancher")
+	if err != nil {
+		return nil, nil, err
+	}
+	clientTemplate.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}
+	clientCert, clientKey, err := signCertificate(clientTemplate, ca, caKey)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(ca)
+	credentials := bytes.Join([][]byte{
+		pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caDER}),
+		clientCert,
+		clientKey,
+	}, nil)
+	return mutualTLS(serving, clientCAs), credentials, nil
+}
+
+func mutualTLS(cert tls.Certificate, clientCAs *x509.CertPool) *tls.Config {
+	return &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    clientCAs,
+		MinVersion:   tls.VersionTLS12,
+	}
+}
+
+func certificateTemplate(commonName string) (*x509.Certificate, error) {
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, fmt.Errorf("error generating serial number: %v", err)
+	}
+	now := time.Now()
+	return &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    now.Add(-time.Minute),
+		NotAfter:     now.Add(ephemeralValidity),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+	}, nil
+}
+
+// signCertificate returns the PEM of a certificate for template signed by ca and of its key
+func signCertificate(template, ca *x509.Certificate, caKey *ecdsa.PrivateKey) ([]byte, []byte, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error generating key: %v", err)
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca, &key.PublicKey, caKey)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error signing certificate %s: %v", template.Subject.CommonName, err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), nil
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"testing"
+
+	"github.com/rancher/example-kontainer-engine-driver/client"
+)
+
+func TestMutualTLS(t *testing.T) {
+	serverConfig, credentials, err := ephemeralTLS("127.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := newRPCServer(newMyDriver(), rpcServerOptions{TLS: serverConfig})
+	defer s.Shutdown(0, 0)
+	address := serveLocal(t, s)
+
+	handshake := client.Handshake{Transport: client.TransportMTLS, Credentials: credentials}
+	trusted := handshake.Options().TLS
+	_, otherCredentials, err := ephemeralTLS("127.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	other := client.Handshake{Transport: client.TransportMTLS, Credentials: otherCredentials}.Options().TLS
+
+	tests := []struct {
+		name     string
+		opts     client.Options
+		accepted bool
+	}{
+		{name: "client certificate of the ephemeral ca", opts: client.Options{TLS: trusted}, accepted: true},
+		{name: "plaintext", opts: client.Options{}},
+		{name: "no client certificate", opts: client.Options{TLS: &tls.Config{RootCAs: trusted.RootCAs}}},
+		{
+			name: "client certificate of another ca",
+			opts: client.Options{TLS: &tls.Config{RootCAs: trusted.RootCAs, Certificates: other.Certificates}},
+		},
+		{name: "server certificate of another ca", opts: client.Options{TLS: other}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			driver, err := client.NewClient("mydriver", address, test.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, err = driver.GetDriverCreateOptions(context.Background())
+			if test.accepted && err != nil {
+				t.Errorf("the connection was rejected: %v", err)
+			}
+			if !test.accepted && err == nil {
+				t.Error("the connection was accepted")
+			}
+		})
+	}
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/rancher/example-kontainer-engine-driver/schema"
+	"github.com/rancher/kontainer-engine/types"
+)
+
+const (
+	// optionMutable options can be changed by an update
+	optionMutable = "mutable"
+	// optionImmutable options are fixed once the cluster is created
+	optionImmutable = "immutable"
+	// optionUpdateOnly options are only accepted by an update
+	optionUpdateOnly = "update-only"
+)
+
+// immutableOptionChecks report whether an immutable option given in the update config differs
+// from what the cluster was created with, they only run for options present in the update
+var immutableOptionChecks = map[string]func(config driverConfig, s state) bool{
+	"name": func(config driverConfig, s state) bool {
+		return config.Name != "" && config.Name != s.Name
+	},
+	"cluster-cidr": func(config driverConfig, s state) bool {
+		return config.ClusterCIDR != "" && config.ClusterCIDR != s.ClusterCIDR
+	},
+	"service-cidr": func(config driverConfig, s state) bool {
+		return config.ServiceCIDR != "" && config.ServiceCIDR != s.ServiceCIDR
+	},
+	"rollback-on-failure": func(config driverConfig, s state) bool {
+		return config.RollbackOnFailure != s.RollbackOnFailure
+	},
+	"create-timeout": func(config driverConfig, s state) bool {
+		return config.CreateTimeout != s.Timeouts.Create
+	},
+	"node-pools": func(config driverConfig, s state) bool {
+		if len(config.NodePools) == 0 {
+			return false
+		}
+		if len(config.NodePools) != len(s.NodePools) {
+			return true
+		}
+		for i, spec := range config.NodePools {
+			pool, err := parseNodePool(spec)
+			if err != nil || !samePoolLayout(pool, s.NodePools[i]) {
+				return true
+			}
+		}
+		return false
+	},
+}
+
+func init() {
+	// an immutable option without a check could silently be changed by an update
+	for _, field := range configFields {
+		if _, ok := immutableOptionChecks[field.Name]; !ok && updateMode(field) == optionImmutable {
+			panic(fmt.Sprintf("immutable option %s has no check in immutableOptionChecks", field.Name))
+		}
+	}
+}
+
+// changedImmutableOptions returns the sorted names of the immutable options an update tries to change
+func changedImmutableOptions(opts *types.DriverOptions, config driverConfig, s state) []string {
+	var changed []string
+	for _, field := range configFields {
+		if updateMode(field) != optionImmutable || !schema.IsSet(opts, field) {
+			continue
+		}
+		if immutableOptionChecks[field.Name](config, s) {
+			changed = append(changed, field.Name)
+		}
+	}
+	sort.Strings(changed)
+	return changed
+}
+
+// updatedNodePools applies the node-count and pool-sizes update options to a copy of pools
+func updatedNodePools(config driverConfig, pools []nodePool) ([]nodePool, error) {
+	result := make([]nodePool, len(pools))
+	copy(result, pools)
+
+	if config.NodeCount != 0 && len(result) == 1 && result[0].Name == defaultNodePoolName {
+		var err error
+		if result, err = resizePools(result, "", defaultNodePoolName, config.NodeCount); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, size := range config.PoolSizes {
+		kv := strings.SplitN(size, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("pool size %q is not of the form NAME=COUNT", size)
+		}
+		n, err := strconv.ParseInt(kv[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("pool size %q is not of the form NAME=COUNT", size)
+		}
+		i, err := resizeTarget(result, "", kv[0])
+		if err != nil {
+			return nil, err
+		}
+		if result, err = resizePools(result, "", kv[0], totalNodeCount(result)-result[i].Count+n); err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
+
+// samePoolLayout compares everything but the node count, which changes when the cluster is resized
+func samePoolLayout(a, b nodePool) bool {
+	if a.Name != b.Name || a.MinCount != b.MinCount || a.MaxCount != b.MaxCount {
+		return false
+	}
+	if len(a.Labels) != len(b.Labels) || len(a.Taints) != len(b.Taints) {
+		return false
+	}
+	for k, v := range a.Labels {
+		if other, ok := b.Labels[k]; !ok || other != v {
+			return false
+		}
+	}
+	for i := range a.Taints {
+		if a.Taints[i] != b.Taints[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"sort"
+
+	"github.com/rancher/example-kontainer-engine-driver/schema"
+	"github.com/rancher/kontainer-engine/types"
+)
+
+// validateCreateConfig decodes the create options and checks every rule before anything is
+// provisioned, all problems are returned together as schema.Errors
+func validateCreateConfig(opts *types.DriverOptions) (driverConfig, error) {
+	config, err := getCreateConfig(opts)
+	errs, err := collectErrors(nil, err)
+	if err != nil {
+		return config, err
+	}
+	if errs, err = collectErrors(errs, schema.Validate(&config)); err != nil {
+		return config, err
+	}
+
+	if config.KubernetesVersion != "" {
+		if _, err := lookupVersion(config.KubernetesVersion); err != nil {
+			errs = append(errs, fieldError("kubernetes-version", err))
+		}
+	}
+
+	pools, poolErrs := validateNodePools(config.NodePools)
+	errs = append(errs, poolErrs...)
+	if len(config.NodePools) == 0 {
+		pools = []nodePool{{Name: defaultNodePoolName, Count: config.NodeCount}}
+	}
+	if config.ResizePool != "" && len(poolErrs) == 0 {
+		if _, err := resizeTarget(pools, "", config.ResizePool); err != nil {
+			errs = append(errs, fieldError("resize-pool", err))
+		}
+	}
+
+	if err := checkCIDROverlap(config.ClusterCIDR, config.ServiceCIDR); err != nil {
+		errs = append(errs, fieldError("service-cidr", err))
+	}
+	errs = append(errs, validateClusterRole(config)...)
+
+	if len(errs) > 0 {
+		return config, errs
+	}
+	return config, nil
+}
+
+// validateUpdateConfig decodes the update options and checks them against the current state
+// of the cluster, all problems are returned together as schema.Errors
+func validateUpdateConfig(opts *types.DriverOptions, s state) (driverConfig, error) {
+	config, err := getUpdateConfig(opts)
+	errs, err := collectErrors(nil, err)
+	if err != nil {
+		return config, err
+	}
+	if errs, err = collectErrors(errs, schema.Validate(&config)); err != nil {
+		return config, err
+	}
+
+	for _, name := range changedImmutableOptions(opts, config, s) {
+		errs = append(errs, schema.FieldError{Option: name, Message: "cannot be changed once the cluster is created"})
+	}
+
+	if config.KubernetesVersion != "" {
+		if err := checkUpgrade(s.KubernetesVersion, config.KubernetesVersion); err != nil {
+			errs = append(errs, fieldError("kubernetes-version", err))
+		}
+	}
+	if config.ResizePool != "" {
+		if _, err := resizeTarget(s.NodePools, "", config.ResizePool); err != nil {
+			errs = append(errs, fieldError("resize-pool", err))
+		}
+	}
+	if _, err := updatedNodePools(config, s.NodePools); err != nil {
+		option := "node-count"
+		if len(config.PoolSizes) > 0 {
+			option = "pool-sizes"
+		}
+		errs = append(errs, fieldError(option, err))
+	}
+	errs = append(errs, validateClusterRole(config)...)
+
+	if len(errs) > 0 {
+		return config, errs
+	}
+	return config, nil
+}
+
+// validateNodePools parses the node-pools option and checks every pool on its own and
+// against the others
+func validateNodePools(specs []string) ([]nodePool, schema.Errors) {
+	var (
+		pools []nodePool
+		errs  schema.Errors
+	)
+	names := map[string]bool{}
+	for _, spec := range specs {
+		pool, err := parseNodePool(spec)
+		if err != nil {
+			errs = append(errs, fieldError("node-pools", err))
+			continue
+		}
+		for _, err := range checkNodePool(pool) {
+			errs = append(errs, fieldError("node-pools", fmt.Errorf("node pool %s: %v", pool.Name, err)))
+		}
+		if names[pool.Name] {
+			errs = append(errs, fieldError("node-pools", fmt.Errorf("node pool %s is defined more than once", pool.Name)))
+		}
+		names[pool.Name] = true
+		pools = append(pools, pool)
+	}
+	return pools, errs
+}
+
+func checkNodePool(pool nodePool) []error {
+	var errs []error
+	if err := schema.ValidateFormat("dns-label", pool.Name); err != nil {
+		errs = append(errs, fmt.Errorf("invalid name: %v", err))
+	}
+	switch {
+	case pool.Count < 0 || pool.MinCount < 0 || pool.MaxCount < 0:
+		errs = append(errs, fmt.Errorf("count, min and max cannot be negative"))
+	case pool.MaxCount > 0 && pool.MinCount > pool.MaxCount:
+		errs = append(errs, fmt.Errorf("min %d is greater than max %d", pool.MinCount, pool.MaxCount))
+	case pool.Count < pool.MinCount:
+		errs = append(errs, fmt.Errorf("count %d is below the minimum of %d", pool.Count, pool.MinCount))
+	case pool.MaxCount > 0 && pool.Count > pool.MaxCount:
+		errs = append(errs, fmt.Errorf("count %d is above the maximum of %d", pool.Count, pool.MaxCount))
+	}
+	keys := make([]string, 0, len(pool.Labels))
+	for key := range pool.Labels {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if err := schema.ValidateLabel(key + "=" + pool.Labels[key]); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errs
+}
+
+// validateClusterRole checks the role options of the bootstrap service account, a cluster role
+// and rules are mutually exclusive
+func validateClusterRole(config driverConfig) schema.Errors {
+	var errs schema.Errors
+	if config.ClusterRole != "" && len(config.ClusterRoleRules) > 0 {
+		errs = append(errs, fieldError("cluster-role-rules", fmt.Errorf("cannot be combined with cluster-role")))
+	}
+	for _, spec := range config.ClusterRoleRules {
+		if _, err := parsePolicyRule(spec); err != nil {
+			errs = append(errs, fieldError("cluster-role-rules", err))
+		}
+	}
+	return errs
+}
+
+// checkCIDROverlap makes sure the pod and service ranges do not share addresses, unset or
+// malformed ranges are reported by the format rules instead
+func checkCIDROverlap(clusterCIDR, serviceCIDR string) error {
+	_, cluster, err := net.ParseCIDR(clusterCIDR)
+	if err != nil {
+		return nil
+	}
+	_, service, err := net.ParseCIDR(serviceCIDR)
+	if err != nil {
+		return nil
+	}
+	if cluster.Contains(service.IP) || service.Contains(cluster.IP) {
+		return fmt.Errorf("%s overlaps with the cluster-cidr %s", serviceCIDR, clusterCIDR)
+	}
+	return nil
+}
+
+// collectErrors appends the field errors of err to errs, any other error is returned as is
+func collectErrors(errs schema.Errors, err error) (schema.Errors, error) {
+	if err == nil {
+		return errs, nil
+	}
+	fieldErrs, ok := err.(schema.Errors)
+	if !ok {
+		return errs, err
+	}
+	return append(errs, fieldErrs...), nil
+}
+
+func fieldError(option string, err error) schema.FieldError {
+	return schema.FieldError{Option: option, Message: err.Error()}
+}
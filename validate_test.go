@@ -0,0 +1,248 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/rancher/example-kontainer-engine-driver/schema"
+	"github.com/rancher/kontainer-engine/types"
+)
+
+// checkErrors fails unless err is schema.Errors matching want in order. The messages in want
+// are prefixes, the messages of the Kubernetes validation helpers are long.
+func checkErrors(t *testing.T, err error, want schema.Errors) {
+	t.Helper()
+	if len(want) == 0 {
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		return
+	}
+	errs, ok := err.(schema.Errors)
+	if !ok {
+		t.Fatalf("error is %#v, want schema.Errors", err)
+	}
+	if len(errs) != len(want) {
+		t.Fatalf("got %d errors, want %d: %v", len(errs), len(want), err)
+	}
+	for i := range want {
+		if errs[i].Option != want[i].Option || !strings.HasPrefix(errs[i].Message, want[i].Message) {
+			t.Errorf("error %d is %q, want %q", i, errs[i], want[i])
+		}
+	}
+}
+
+func TestValidateCreateConfig(t *testing.T) {
+	tests := []struct {
+		name string
+		opts *types.DriverOptions
+		want schema.Errors
+	}{
+		{
+			name: "defaults",
+			opts: &types.DriverOptions{StringOptions: map[string]string{"name": "c1"}},
+		},
+		{
+			name: "empty strings are defaults",
+			opts: &types.DriverOptions{StringOptions: map[string]string{
+				"name":               "c1",
+				"kubernetes-version": "",
+				"resize-policy":      "",
+				"cluster-cidr":       "",
+			}},
+		},
+		{
+			name: "missing name",
+			opts: &types.DriverOptions{},
+			want: schema.Errors{{Option: "name", Message: "is required"}},
+		},
+		{
+			name: "option rules and cidr overlap",
+			opts: &types.DriverOptions{
+				StringOptions: map[string]string{
+					"name":               "Bad_Name",
+					"cluster-cidr":       "10.0.0.0/8",
+					"resize-policy":      "biggest",
+					"kubernetes-version": "v2.0.0",
+					"create-timeout":     "-1m",
+				},
+				IntOptions: map[string]int64{"node-count": 1001},
+			},
+			want: schema.Errors{
+				{Option: "name", Message: `"Bad_Name" is not a valid DNS label`},
+				{Option: "node-count", Message: "1001 is more than the maximum of 1000"},
+				{Option: "resize-policy", Message: `"biggest" is not one of last, first, largest, smallest`},
+				{Option: "create-timeout", Message: "-1m0s is less than the minimum of 0s"},
+				{Option: "kubernetes-version", Message: "kubernetes version v2.0.0 is not supported"},
+				{Option: "service-cidr", Message: "10.43.0.0/16 overlaps with the cluster-cidr 10.0.0.0/8"},
+			},
+		},
+		{
+			name: "service cidr inside the cluster cidr",
+			opts: &types.DriverOptions{StringOptions: map[string]string{
+				"name":         "c1",
+				"cluster-cidr": "10.42.0.0/16",
+				"service-cidr": "10.42.128.0/20",
+			}},
+			want: schema.Errors{{Option: "service-cidr", Message: "10.42.128.0/20 overlaps with the cluster-cidr 10.42.0.0/16"}},
+		},
+		{
+			name: "malformed cidrs are not checked for overlap",
+			opts: &types.DriverOptions{StringOptions: map[string]string{
+				"name":         "c1",
+				"cluster-cidr": "10.42.0.0",
+				"service-cidr": "10.42.0.0/33",
+			}},
+			want: schema.Errors{
+				{Option: "cluster-cidr", Message: `"10.42.0.0" is not a valid CIDR`},
+				{Option: "service-cidr", Message: `"10.42.0.0/33" is not a valid CIDR`},
+			},
+		},
+		{
+			name: "node pools",
+			opts: &types.DriverOptions{
+				StringOptions: map[string]string{"name": "c1", "resize-pool": "x"},
+				StringSliceOptions: map[string]*types.StringSlice{"node-pools": {Value: []string{
+					"name=a,count=5,max=3",
+					"name=a,count=1",
+					"name=B,count=-1",
+					"count=2",
+					"name=c,count=1,min=3,max=2",
+					"name=d,count=1,label=bad key=v",
+				}}},
+			},
+			want: schema.Errors{
+				{Option: "node-pools", Message: "node pool a: count 5 is above the maximum of 3"},
+				{Option: "node-pools", Message: "node pool a is defined more than once"},
+				{Option: "node-pools", Message: `node pool B: invalid name: "B" is not a valid DNS label`},
+				{Option: "node-pools", Message: "node pool B: count, min and max cannot be negative"},
+				{Option: "node-pools", Message: `node pool "count=2": name is required`},
+				{Option: "node-pools", Message: "node pool c: min 3 is greater than max 2"},
+				{Option: "node-pools", Message: `node pool d: label "bad key=v" has an invalid key`},
+			},
+		},
+		{
+			name: "count below the minimum of a pool",
+			opts: &types.DriverOptions{
+				StringOptions:      map[string]string{"name": "c1"},
+				StringSliceOptions: map[string]*types.StringSlice{"node-pools": {Value: []string{"name=a,count=1,min=2"}}},
+			},
+			want: schema.Errors{{Option: "node-pools", Message: "node pool a: count 1 is below the minimum of 2"}},
+		},
+		{
+			name: "resize pool of valid node pools",
+			opts: &types.DriverOptions{
+				StringOptions:      map[string]string{"name": "c1", "resize-pool": "x"},
+				StringSliceOptions: map[string]*types.StringSlice{"node-pools": {Value: []string{"name=a,count=1"}}},
+			},
+			want: schema.Errors{{Option: "resize-pool", Message: "node pool x does not exist"}},
+		},
+		{
+			name: "cluster role and rules",
+			opts: &types.DriverOptions{
+				StringOptions: map[string]string{"name": "c1", "cluster-role": "view"},
+				StringSliceOptions: map[string]*types.StringSlice{"cluster-role-rules": {Value: []string{
+					"verb=get,resource=pods",
+					"group=x",
+				}}},
+			},
+			want: schema.Errors{
+				{Option: "cluster-role-rules", Message: "cannot be combined with cluster-role"},
+				{Option: "cluster-role-rules", Message: `rule "group=x": at least one verb is required`},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := validateCreateConfig(test.opts)
+			checkErrors(t, err, test.want)
+		})
+	}
+}
+
+func TestValidateUpdateConfig(t *testing.T) {
+	current, err := getStateFromOpts(&types.DriverOptions{
+		StringOptions:      map[string]string{"name": "c1", "kubernetes-version": "v1.10.5"},
+		StringSliceOptions: map[string]*types.StringSlice{"node-pools": {Value: []string{"name=a,count=2,max=5", "name=b,count=1"}}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		opts *types.DriverOptions
+		want schema.Errors
+	}{
+		{
+			name: "nothing",
+			opts: &types.DriverOptions{},
+		},
+		{
+			name: "unchanged immutable options",
+			opts: &types.DriverOptions{
+				StringOptions:      map[string]string{"name": "c1", "cluster-cidr": defaultClusterCIDR, "create-timeout": "30m"},
+				BoolOptions:        map[string]bool{"rollback-on-failure": false},
+				StringSliceOptions: map[string]*types.StringSlice{"node-pools": {Value: []string{"name=a,count=4,max=5", "name=b,count=1"}}},
+			},
+		},
+		{
+			name: "empty strings are unset",
+			opts: &types.DriverOptions{StringOptions: map[string]string{"name": "", "service-cidr": "", "resize-policy": "", "update-timeout": ""}},
+		},
+		{
+			name: "changed immutable options",
+			opts: &types.DriverOptions{
+				StringOptions: map[string]string{"name": "c2", "serviceCidr": "10.96.0.0/12", "createTimeout": "1h"},
+				BoolOptions:   map[string]bool{"rollbackOnFailure": true},
+				StringSliceOptions: map[string]*types.StringSlice{"node-pools": {Value: []string{
+					"name=a,count=2,max=6",
+					"name=b,count=1",
+				}}},
+			},
+			want: schema.Errors{
+				{Option: "create-timeout", Message: "cannot be changed once the cluster is created"},
+				{Option: "name", Message: "cannot be changed once the cluster is created"},
+				{Option: "node-pools", Message: "cannot be changed once the cluster is created"},
+				{Option: "rollback-on-failure", Message: "cannot be changed once the cluster is created"},
+				{Option: "service-cidr", Message: "cannot be changed once the cluster is created"},
+			},
+		},
+		{
+			name: "every problem is reported",
+			opts: &types.DriverOptions{
+				StringOptions: map[string]string{
+					"cluster-cidr":       "10.0.0.0/8",
+					"kubernetes-version": "v1.8.11",
+					"resize-pool":        "x",
+					"resize-policy":      "random",
+					"update-timeout":     "-5s",
+				},
+				IntOptions: map[string]int64{"node-count": 5000},
+			},
+			want: schema.Errors{
+				{Option: "node-count", Message: "5000 is more than the maximum of 1000"},
+				{Option: "resize-policy", Message: `"random" is not one of last, first, largest, smallest`},
+				{Option: "update-timeout", Message: "-5s is less than the minimum of 0s"},
+				{Option: "cluster-cidr", Message: "cannot be changed once the cluster is created"},
+				{Option: "kubernetes-version", Message: "downgrading from v1.10.5 to v1.8.11 is not supported"},
+				{Option: "resize-pool", Message: "node pool x does not exist"},
+			},
+		},
+		{
+			name: "pool size above the maximum",
+			opts: &types.DriverOptions{
+				StringSliceOptions: map[string]*types.StringSlice{"pool-sizes": {Value: []string{"a=9"}}},
+			},
+			want: schema.Errors{{Option: "pool-sizes", Message: "cannot resize the cluster to 10 nodes, node pool a would have 9 nodes which is above its maximum of 5"}},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := validateUpdateConfig(test.opts, current)
+			checkErrors(t, err, test.want)
+		})
+	}
+}
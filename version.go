@@ -0,0 +1,91 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const defaultKubernetesVersion = "v1.10.5"
+
+// kubernetesVersions is the catalog of versions MyDriver can provision, oldest first
+var kubernetesVersions = []string{
+	"v1.8.11",
+	"v1.9.7",
+	"v1.10.3",
+	"v1.10.5",
+	"v1.11.1",
+}
+
+type semver struct {
+	major, minor, patch int
+}
+
+func (v semver) String() string {
+	return fmt.Sprintf("v%d.%d.%d", v.major, v.minor, v.patch)
+}
+
+func (v semver) compare(other semver) int {
+	switch {
+	case v.major != other.major:
+		return v.major - other.major
+	case v.minor != other.minor:
+		return v.minor - other.minor
+	default:
+		return v.patch - other.patch
+	}
+}
+
+func parseVersion(version string) (semver, error) {
+	parts := strings.Split(strings.TrimPrefix(version, "v"), ".")
+	if len(parts) != 3 {
+		return semver{}, fmt.Errorf("version %q is not of the form vMAJOR.MINOR.PATCH", version)
+	}
+	var numbers [3]int
+	for i, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 {
+			return semver{}, fmt.Errorf("version %q is not of the form vMAJOR.MINOR.PATCH", version)
+		}
+		numbers[i] = n
+	}
+	return semver{major: numbers[0], minor: numbers[1], patch: numbers[2]}, nil
+}
+
+// lookupVersion normalizes a version and makes sure it is in the catalog
+func lookupVersion(version string) (semver, error) {
+	v, err := parseVersion(version)
+	if err != nil {
+		return v, err
+	}
+	for _, known := range kubernetesVersions {
+		if known == v.String() {
+			return v, nil
+		}
+	}
+	return v, fmt.Errorf("kubernetes version %s is not supported, supported versions are %s", v, strings.Join(kubernetesVersions, ", "))
+}
+
+// checkUpgrade enforces the upgrade path rules: the target has to be in the catalog, downgrades
+// are refused and so is skipping a minor version
+func checkUpgrade(current, target string) error {
+	to, err := lookupVersion(target)
+	if err != nil {
+		return err
+	}
+	from, err := parseVersion(current)
+	if err != nil {
+		return fmt.Errorf("current cluster version is invalid: %v", err)
+	}
+
+	switch {
+	case to.compare(from) < 0:
+		return fmt.Errorf("downgrading from %s to %s is not supported", from, to)
+	case to.major != from.major:
+		return fmt.Errorf("upgrading across major versions from %s to %s is not supported", from, to)
+	case to.minor-from.minor > 1:
+		return fmt.Errorf("upgrading from %s to %s would skip minor version %d.%d, upgrade to the latest %d.%d release first",
+			from, to, from.major, from.minor+1, from.major, from.minor+1)
+	}
+	return nil
+}
@@ -0,0 +1,130 @@
+package main
+
+import "testing"
+
+func TestLookupVersion(t *testing.T) {
+	tests := []struct {
+		version string
+		want    string
+		wantErr string
+	}{
+		{version: "v1.10.5", want: "v1.10.5"},
+		{version: "1.10.5", want: "v1.10.5"},
+		{version: "v01.010.05", want: "v1.10.5"},
+		{version: "v1.10.4", wantErr: "kubernetes version v1.10.4 is not supported, supported versions are v1.8.11, v1.9.7, v1.10.3, v1.10.5, v1.11.1"},
+		{version: "v1.10", wantErr: `version "v1.10" is not of the form vMAJOR.MINOR.PATCH`},
+		{version: "v1.10.5-rancher1", wantErr: `version "v1.10.5-rancher1" is not of the form vMAJOR.MINOR.PATCH`},
+		{version: "v1.-10.5", wantErr: `version "v1.-10.5" is not of the form vMAJOR.MINOR.PATCH`},
+		{version: "", wantErr: `version "" is not of the form vMAJOR.MINOR.PATCH`},
+	}
+
+	for _, test := range tests {
+		t.Run(test.version, func(t *testing.T) {
+			v, err := lookupVersion(test.version)
+			if test.wantErr != "" {
+				if err == nil || err.Error() != test.wantErr {
+					t.Fatalf("error is %v, want %q", err, test.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if v.String() != test.want {
+				t.Errorf("version is %s, want %s", v, test.want)
+			}
+		})
+	}
+}
+
+func TestCheckUpgrade(t *testing.T) {
+	tests := []struct {
+		name    string
+		catalog []string
+		current string
+		target  string
+		wantErr string
+	}{
+		{name: "patch", current: "v1.10.3", target: "v1.10.5"},
+		{name: "next minor", current: "v1.10.5", target: "v1.11.1"},
+		{name: "same version", current: "v1.10.5", target: "v1.10.5"},
+		{name: "target without v", current: "v1.9.7", target: "1.10.3"},
+		{name: "current outside the catalog", current: "v1.10.0", target: "v1.10.5"},
+		{
+			name:    "downgrade patch",
+			current: "v1.10.5",
+			target:  "v1.10.3",
+			wantErr: "downgrading from v1.10.5 to v1.10.3 is not supported",
+		},
+		{
+			name:    "downgrade minor",
+			current: "v1.11.1",
+			target:  "v1.8.11",
+			wantErr: "downgrading from v1.11.1 to v1.8.11 is not supported",
+		},
+		{
+			name:    "skips a minor version",
+			current: "v1.9.7",
+			target:  "v1.11.1",
+			wantErr: "upgrading from v1.9.7 to v1.11.1 would skip minor version 1.10, upgrade to the latest 1.10 release first",
+		},
+		{
+			name:    "skips two minor versions",
+			current: "v1.8.11",
+			target:  "v1.11.1",
+			wantErr: "upgrading from v1.8.11 to v1.11.1 would skip minor version 1.9, upgrade to the latest 1.9 release first",
+		},
+		{
+			name:    "major version",
+			catalog: []string{"v1.11.1", "v2.0.0"},
+			current: "v1.11.1",
+			target:  "v2.0.0",
+			wantErr: "upgrading across major versions from v1.11.1 to v2.0.0 is not supported",
+		},
+		{
+			name:    "major version downgrade",
+			catalog: []string{"v1.11.1", "v2.0.0"},
+			current: "v2.0.0",
+			target:  "v1.11.1",
+			wantErr: "downgrading from v2.0.0 to v1.11.1 is not supported",
+		},
+		{
+			name:    "target outside the catalog",
+			current: "v1.10.5",
+			target:  "v1.10.6",
+			wantErr: "kubernetes version v1.10.6 is not supported, supported versions are v1.8.11, v1.9.7, v1.10.3, v1.10.5, v1.11.1",
+		},
+		{
+			name:    "invalid target",
+			current: "v1.10.5",
+			target:  "latest",
+			wantErr: `version "latest" is not of the form vMAJOR.MINOR.PATCH`,
+		},
+		{
+			name:    "invalid current version",
+			current: "unknown",
+			target:  "v1.10.5",
+			wantErr: `current cluster version is invalid: version "unknown" is not of the form vMAJOR.MINOR.PATCH`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if test.catalog != nil {
+				defer func(catalog []string) { kubernetesVersions = catalog }(kubernetesVersions)
+				kubernetesVersions = test.catalog
+			}
+
+			err := checkUpgrade(test.current, test.target)
+			if test.wantErr == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || err.Error() != test.wantErr {
+				t.Fatalf("error is %v, want %q", err, test.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,192 @@
+// Package wait polls a condition until it holds, backing off exponentially between attempts.
+// It replaces the hand rolled sleep loops of the drivers with one that gives up on context
+// cancellation, an overall deadline or a maximum number of attempts, and that keeps the user
+// informed through the Rancher log stream while it waits.
+//
+//	err := wait.Poller{
+//		Description: "the api server of cluster c1",
+//		Backoff:     wait.DefaultBackoff,
+//		Logger:      logger,
+//	}.Until(ctx, func(ctx context.Context) (bool, error) {
+//		return server.Ready(ctx)
+//	})
+package wait
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"time"
+
+	"github.com/rancher/kontainer-engine/logstream"
+)
+
+var (
+	// ErrTimeout is returned when the condition did not hold before Backoff.Timeout passed
+	ErrTimeout = errors.New("timed out waiting for the condition")
+	// ErrMaxAttempts is returned when the condition did not hold after Backoff.MaxAttempts attempts
+	ErrMaxAttempts = errors.New("the condition did not hold after the maximum number of attempts")
+)
+
+// DefaultBackoff starts polling quickly and settles on one attempt every 10 seconds
+var DefaultBackoff = Backoff{
+	Initial:  250 * time.Millisecond,
+	Max:      10 * time.Second,
+	Factor:   2,
+	Jitter:   0.2,
+	Progress: 30 * time.Second,
+}
+
+// Backoff controls how often and for how long a condition is polled
+type Backoff struct {
+	// Initial is the delay after the first attempt
+	Initial time.Duration
+	// Max caps the delay between attempts, 0 means no cap
+	Max time.Duration
+	// Factor multiplies the delay after every attempt, values below 1 keep it constant
+	Factor float64
+	// Jitter randomizes every delay by up to this fraction of it in either direction
+	Jitter float64
+	// MaxAttempts is the number of attempts before giving up, 0 means no limit
+	MaxAttempts int
+	// Timeout is how long to poll before giving up, 0 means until the context is done
+	Timeout time.Duration
+	// Progress is how often a progress message is logged while waiting, 0 disables them
+	Progress time.Duration
+}
+
+// delay returns the delay after the given attempt, attempts count from 1
+func (b Backoff) delay(attempt int, random func() float64) time.Duration {
+	d := float64(b.Initial)
+	if b.Factor > 1 {
+		for i := 1; i < attempt; i++ {
+			d *= b.Factor
+			if b.Max > 0 && d > float64(b.Max) {
+				break
+			}
+		}
+	}
+	if b.Max > 0 && d > float64(b.Max) {
+		d = float64(b.Max)
+	}
+	if b.Jitter > 0 {
+		d += d * b.Jitter * (2*random() - 1)
+	}
+	if d < 0 {
+		return 0
+	}
+	return time.Duration(d)
+}
+
+// Clock is the source of time of a Poller, tests inject a fake one to poll without sleeping
+type Clock interface {
+	Now() time.Time
+	After(d time.Duration) <-chan time.Time
+}
+
+// RealClock is the wall clock
+var RealClock Clock = realClock{}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
+// ConditionFunc reports whether the awaited condition holds. An error stops the polling, a
+// condition that can recover from a failure should report false instead.
+type ConditionFunc func(ctx context.Context) (bool, error)
+
+// Poller polls a condition
+type Poller struct {
+	// Description names what is awaited in progress messages, such as "the nodes of cluster c1"
+	Description string
+	// Backoff controls the timing of the attempts
+	Backoff Backoff
+	// Logger receives the progress messages, nil disables them
+	Logger logstream.Logger
+	// Clock defaults to RealClock
+	Clock Clock
+	// Random returns a number in [0, 1) for the jitter, it defaults to math/rand
+	Random func() float64
+}
+
+// Until polls condition until it holds, it fails, the context is done, Backoff.Timeout passed
+// or Backoff.MaxAttempts attempts were made. A done context returns ctx.Err().
+func (p Poller) Until(ctx context.Context, condition ConditionFunc) error {
+	clock := p.Clock
+	if clock == nil {
+		clock = RealClock
+	}
+	random := p.Random
+	if random == nil {
+		random = rand.Float64
+	}
+
+	start := clock.Now()
+	var deadline <-chan time.Time
+	if p.Backoff.Timeout > 0 {
+		deadline = clock.After(p.Backoff.Timeout)
+	}
+	lastProgress := start
+
+	for attempt := 1; ; attempt++ {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		done, err := condition(ctx)
+		if err != nil {
+			return err
+		}
+		if done {
+			if attempt > 1 {
+				p.infof("%s is ready after %s", p.Description, roundDuration(clock.Now().Sub(start)))
+			}
+			return nil
+		}
+		if p.Backoff.MaxAttempts > 0 && attempt >= p.Backoff.MaxAttempts {
+			p.warnf("gave up waiting for %s after %d attempts", p.Description, attempt)
+			return ErrMaxAttempts
+		}
+
+		now := clock.Now()
+		if attempt == 1 {
+			p.infof("waiting for %s", p.Description)
+		} else if p.Backoff.Progress > 0 && now.Sub(lastProgress) >= p.Backoff.Progress {
+			p.infof("still waiting for %s, %s elapsed after %d attempts", p.Description, roundDuration(now.Sub(start)), attempt)
+			lastProgress = now
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-deadline:
+			p.warnf("gave up waiting for %s after %s", p.Description, roundDuration(clock.Now().Sub(start)))
+			return ErrTimeout
+		case <-clock.After(p.Backoff.delay(attempt, random)):
+		}
+	}
+}
+
+func (p Poller) infof(msg string, args ...interface{}) {
+	if p.Logger != nil {
+		p.Logger.Infof(msg, args...)
+	}
+}
+
+func (p Poller) warnf(msg string, args ...interface{}) {
+	if p.Logger != nil {
+		p.Logger.Warnf(msg, args...)
+	}
+}
+
+func roundDuration(d time.Duration) time.Duration {
+	if d < time.Second {
+		return d.Round(time.Millisecond)
+	}
+	return d.Round(time.Second)
+}
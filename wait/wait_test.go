@@ -0,0 +1,396 @@
+package wait
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+// fakeClock moves time forward by itself. Once as many timers are pending as a blocked Poller
+// holds, one for the deadline when there is a timeout and one for the delay, it jumps to the
+// earliest of them and fires every timer due by then.
+type fakeClock struct {
+	start   time.Time
+	now     time.Time
+	blocked int
+	timers  []fakeTimer
+	// delays are the durations the Poller waited for, the deadline excluded
+	delays []time.Duration
+}
+
+type fakeTimer struct {
+	at time.Time
+	c  chan time.Time
+}
+
+func newFakeClock(b Backoff) *fakeClock {
+	start := time.Date(2018, 7, 1, 0, 0, 0, 0, time.UTC)
+	clock := &fakeClock{start: start, now: start, blocked: 1}
+	if b.Timeout > 0 {
+		clock.blocked = 2
+	}
+	return clock
+}
+
+func (c *fakeClock) Now() time.Time {
+	return c.now
+}
+
+func (c *fakeClock) After(d time.Duration) <-chan time.Time {
+	timer := fakeTimer{at: c.now.Add(d), c: make(chan time.Time, 1)}
+	c.timers = append(c.timers, timer)
+	if len(c.timers) < c.blocked {
+		return timer.c
+	}
+
+	c.delays = append(c.delays, d)
+	sort.Slice(c.timers, func(i, j int) bool { return c.timers[i].at.Before(c.timers[j].at) })
+	c.now = c.timers[0].at
+	var pending []fakeTimer
+	for _, t := range c.timers {
+		if t.at.After(c.now) {
+			pending = append(pending, t)
+			continue
+		}
+		t.c <- t.at
+	}
+	c.timers = pending
+	return timer.c
+}
+
+// elapsed is the fake time passed since the clock was created
+func (c *fakeClock) elapsed() time.Duration {
+	return c.now.Sub(c.start)
+}
+
+// recordingLogger keeps the messages along with the fake time they were logged at
+type recordingLogger struct {
+	clock    *fakeClock
+	messages []string
+}
+
+func (l *recordingLogger) Infof(msg string, args ...interface{}) {
+	l.messages = append(l.messages, fmt.Sprintf("%s info: %s", l.clock.elapsed(), fmt.Sprintf(msg, args...)))
+}
+
+func (l *recordingLogger) Warnf(msg string, args ...interface{}) {
+	l.messages = append(l.messages, fmt.Sprintf("%s warn: %s", l.clock.elapsed(), fmt.Sprintf(msg, args...)))
+}
+
+// readyAfter is a condition that holds from the given attempt on and counts the attempts
+func readyAfter(attempt int, attempts *int) ConditionFunc {
+	return func(ctx context.Context) (bool, error) {
+		*attempts++
+		return attempt > 0 && *attempts >= attempt, nil
+	}
+}
+
+func TestBackoffDelay(t *testing.T) {
+	tests := []struct {
+		name    string
+		backoff Backoff
+		random  float64
+		want    []time.Duration
+	}{
+		{
+			name:    "constant",
+			backoff: Backoff{Initial: time.Second},
+			want:    []time.Duration{time.Second, time.Second, time.Second},
+		},
+		{
+			name:    "factor below 1 keeps the delay",
+			backoff: Backoff{Initial: time.Second, Factor: 0.5},
+			want:    []time.Duration{time.Second, time.Second, time.Second},
+		},
+		{
+			name:    "grows by the factor",
+			backoff: Backoff{Initial: time.Second, Factor: 2},
+			want:    []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 8 * time.Second, 16 * time.Second},
+		},
+		{
+			name:    "capped",
+			backoff: Backoff{Initial: time.Second, Factor: 3, Max: 10 * time.Second},
+			want:    []time.Duration{time.Second, 3 * time.Second, 9 * time.Second, 10 * time.Second, 10 * time.Second},
+		},
+		{
+			name:    "initial above the cap",
+			backoff: Backoff{Initial: time.Minute, Factor: 2, Max: 10 * time.Second},
+			want:    []time.Duration{10 * time.Second, 10 * time.Second},
+		},
+		{
+			name:    "jitter at the top of the range",
+			backoff: Backoff{Initial: time.Second, Factor: 2, Max: 4 * time.Second, Jitter: 0.5},
+			random:  1,
+			want:    []time.Duration{1500 * time.Millisecond, 3 * time.Second, 6 * time.Second, 6 * time.Second},
+		},
+		{
+			name:    "jitter at the bottom of the range",
+			backoff: Backoff{Initial: time.Second, Factor: 2, Max: 4 * time.Second, Jitter: 0.5},
+			random:  0,
+			want:    []time.Duration{500 * time.Millisecond, time.Second, 2 * time.Second, 2 * time.Second},
+		},
+		{
+			name:    "jitter never goes below zero",
+			backoff: Backoff{Initial: time.Second, Jitter: 2},
+			random:  0,
+			want:    []time.Duration{0, 0},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var got []time.Duration
+			for attempt := 1; attempt <= len(test.want); attempt++ {
+				got = append(got, test.backoff.delay(attempt, func() float64 { return test.random }))
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("delays are %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestPollerUntil(t *testing.T) {
+	tests := []struct {
+		name    string
+		backoff Backoff
+		// readyAt is the attempt the condition holds at, 0 never
+		readyAt      int
+		wantErr      error
+		wantAttempts int
+		wantDelays   []time.Duration
+		wantElapsed  time.Duration
+	}{
+		{
+			name:         "ready at once",
+			backoff:      Backoff{Initial: time.Second, Factor: 2},
+			readyAt:      1,
+			wantAttempts: 1,
+		},
+		{
+			name:         "backs off until ready",
+			backoff:      Backoff{Initial: time.Second, Factor: 2},
+			readyAt:      4,
+			wantAttempts: 4,
+			wantDelays:   []time.Duration{time.Second, 2 * time.Second, 4 * time.Second},
+			wantElapsed:  7 * time.Second,
+		},
+		{
+			name:         "backoff stops growing at the cap",
+			backoff:      Backoff{Initial: time.Second, Factor: 2, Max: 5 * time.Second},
+			readyAt:      6,
+			wantAttempts: 6,
+			wantDelays:   []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second},
+			wantElapsed:  17 * time.Second,
+		},
+		{
+			name:         "gives up after the maximum attempts",
+			backoff:      Backoff{Initial: time.Second, Factor: 2, MaxAttempts: 3},
+			wantErr:      ErrMaxAttempts,
+			wantAttempts: 3,
+			wantDelays:   []time.Duration{time.Second, 2 * time.Second},
+			wantElapsed:  3 * time.Second,
+		},
+		{
+			name:         "ready at the last attempt",
+			backoff:      Backoff{Initial: time.Second, MaxAttempts: 3},
+			readyAt:      3,
+			wantAttempts: 3,
+			wantDelays:   []time.Duration{time.Second, time.Second},
+			wantElapsed:  2 * time.Second,
+		},
+		{
+			name:         "times out",
+			backoff:      Backoff{Initial: time.Second, Factor: 2, Timeout: 10 * time.Second},
+			wantErr:      ErrTimeout,
+			wantAttempts: 4,
+			wantDelays:   []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 8 * time.Second},
+			wantElapsed:  10 * time.Second,
+		},
+		{
+			name:         "ready before the timeout",
+			backoff:      Backoff{Initial: time.Second, Factor: 2, Timeout: 10 * time.Second},
+			readyAt:      4,
+			wantAttempts: 4,
+			wantDelays:   []time.Duration{time.Second, 2 * time.Second, 4 * time.Second},
+			wantElapsed:  7 * time.Second,
+		},
+		{
+			name:         "timeout before the maximum attempts",
+			backoff:      Backoff{Initial: 3 * time.Second, Timeout: 10 * time.Second, MaxAttempts: 10},
+			wantErr:      ErrTimeout,
+			wantAttempts: 4,
+			wantDelays:   []time.Duration{3 * time.Second, 3 * time.Second, 3 * time.Second, 3 * time.Second},
+			wantElapsed:  10 * time.Second,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			clock := newFakeClock(test.backoff)
+			attempts := 0
+			err := Poller{
+				Description: "the test",
+				Backoff:     test.backoff,
+				Clock:       clock,
+			}.Until(context.Background(), readyAfter(test.readyAt, &attempts))
+
+			if err != test.wantErr {
+				t.Errorf("error is %v, want %v", err, test.wantErr)
+			}
+			if attempts != test.wantAttempts {
+				t.Errorf("made %d attempts, want %d", attempts, test.wantAttempts)
+			}
+			if !reflect.DeepEqual(clock.delays, test.wantDelays) {
+				t.Errorf("waited %v, want %v", clock.delays, test.wantDelays)
+			}
+			if clock.elapsed() != test.wantElapsed {
+				t.Errorf("took %s, want %s", clock.elapsed(), test.wantElapsed)
+			}
+		})
+	}
+}
+
+func TestPollerUntilConditionError(t *testing.T) {
+	fail := fmt.Errorf("the condition failed")
+	attempts := 0
+	err := Poller{
+		Backoff: Backoff{Initial: time.Second},
+		Clock:   newFakeClock(Backoff{}),
+	}.Until(context.Background(), func(ctx context.Context) (bool, error) {
+		attempts++
+		if attempts == 2 {
+			return false, fail
+		}
+		return false, nil
+	})
+	if err != fail {
+		t.Errorf("error is %v, want %v", err, fail)
+	}
+	if attempts != 2 {
+		t.Errorf("made %d attempts, want 2", attempts)
+	}
+}
+
+func TestPollerUntilContextCanceled(t *testing.T) {
+	tests := []struct {
+		name     string
+		cancelAt int
+		wantErr  error
+	}{
+		{name: "before the first attempt", cancelAt: 0, wantErr: context.Canceled},
+		{name: "while waiting", cancelAt: 3, wantErr: context.Canceled},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			if test.cancelAt == 0 {
+				cancel()
+			}
+
+			attempts := 0
+			err := Poller{
+				Backoff: Backoff{Initial: time.Second, Timeout: time.Hour},
+				Clock:   newFakeClock(Backoff{Timeout: time.Hour}),
+			}.Until(ctx, func(ctx context.Context) (bool, error) {
+				attempts++
+				if attempts == test.cancelAt {
+					cancel()
+				}
+				return false, nil
+			})
+			if err != test.wantErr {
+				t.Errorf("error is %v, want %v", err, test.wantErr)
+			}
+			if attempts != test.cancelAt {
+				t.Errorf("made %d attempts, want %d", attempts, test.cancelAt)
+			}
+		})
+	}
+}
+
+func TestPollerUntilProgress(t *testing.T) {
+	tests := []struct {
+		name    string
+		backoff Backoff
+		readyAt int
+		want    []string
+	}{
+		{
+			name:    "ready at once is silent",
+			backoff: Backoff{Initial: 10 * time.Second, Progress: 30 * time.Second},
+			readyAt: 1,
+		},
+		{
+			name:    "progress every 30 seconds",
+			backoff: Backoff{Initial: 10 * time.Second, Progress: 30 * time.Second},
+			readyAt: 8,
+			want: []string{
+				"0s info: waiting for the test",
+				"30s info: still waiting for the test, 30s elapsed after 4 attempts",
+				"1m0s info: still waiting for the test, 1m0s elapsed after 7 attempts",
+				"1m10s info: the test is ready after 1m10s",
+			},
+		},
+		{
+			name:    "progress disabled",
+			backoff: Backoff{Initial: 10 * time.Second},
+			readyAt: 8,
+			want: []string{
+				"0s info: waiting for the test",
+				"1m10s info: the test is ready after 1m10s",
+			},
+		},
+		{
+			name:    "progress no more often than the attempts",
+			backoff: Backoff{Initial: time.Second, Factor: 4, Progress: 2 * time.Second},
+			readyAt: 5,
+			want: []string{
+				"0s info: waiting for the test",
+				"5s info: still waiting for the test, 5s elapsed after 3 attempts",
+				"21s info: still waiting for the test, 21s elapsed after 4 attempts",
+				"1m25s info: the test is ready after 1m25s",
+			},
+		},
+		{
+			name:    "maximum attempts",
+			backoff: Backoff{Initial: 10 * time.Second, MaxAttempts: 3, Progress: 30 * time.Second},
+			want: []string{
+				"0s info: waiting for the test",
+				"20s warn: gave up waiting for the test after 3 attempts",
+			},
+		},
+		{
+			name:    "timeout",
+			backoff: Backoff{Initial: 10 * time.Second, Timeout: 45 * time.Second, Progress: 30 * time.Second},
+			want: []string{
+				"0s info: waiting for the test",
+				"30s info: still waiting for the test, 30s elapsed after 4 attempts",
+				"45s warn: gave up waiting for the test after 45s",
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			clock := newFakeClock(test.backoff)
+			logger := &recordingLogger{clock: clock}
+			attempts := 0
+			Poller{
+				Description: "the test",
+				Backoff:     test.backoff,
+				Logger:      logger,
+				Clock:       clock,
+			}.Until(context.Background(), readyAfter(test.readyAt, &attempts))
+
+			if !reflect.DeepEqual(logger.messages, test.want) {
+				t.Errorf("logged\n%q\nwant\n%q", logger.messages, test.want)
+			}
+		})
+	}
+}